@@ -5,12 +5,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 )
 
 // AesCtrEncryptor represents an AES encryptor/decryptor
 type AesCtrEncryptor struct {
-	// Debug represents whether debug logging is turned on or not
+	// Debug is unused; the encryptor never logs, as everything it handles
+	// is key material or plain text.
 	Debug bool
 
 	ctrValue    []byte // Current CTR value (used as input to AES to derive pad)
@@ -34,13 +34,15 @@ func NewAesCtrEncryptor(rawKey []byte, nonce []byte) AesCtrEncryptor {
 	return obj
 }
 
-// TODO: Deal with implementing destructor
-/*
-Aes_ctr_encryptor::~Aes_ctr_encryptor ()
-{
-        explicit_memset(pad, '\0', BLOCK_LEN);
+// Destroy wipes the pad and CTR value, which are derived from the key, and
+// resets the byte counter. The key itself belongs to the caller, and is
+// not wiped. The encryptor cannot be used afterwards.
+func (a *AesCtrEncryptor) Destroy() {
+	wipe(a.pad)
+	wipe(a.ctrValue)
+	a.byteCounter = 0
+	a.key = nil
 }
-*/
 
 func (a *AesCtrEncryptor) process(in []byte, out []byte, len uint32) error {
 	var i uint32
@@ -51,13 +53,7 @@ func (a *AesCtrEncryptor) process(in []byte, out []byte, len uint32) error {
 			// Set last 4 bytes of CTR to the (big-endian) block number (sequentially increasing with each block)
 			tmp := make([]byte, 4)
 			binary.BigEndian.PutUint32(tmp, a.byteCounter/aesEncryptorBlockLen)
-			if a.Debug {
-				log.Printf("tmp = %#v, bytecounter = %d, blockLen = %d", tmp, a.byteCounter, aesEncryptorBlockLen)
-			}
 			//store_be32(ctr_value + NONCE_LEN, byte_counter / BLOCK_LEN)
-			if a.Debug {
-				log.Printf("last four bytes of CTR : %x", tmp)
-			}
 			for i := range 4 {
 				a.ctrValue[aesEncryptorNonceLen+i] = tmp[i]
 			}
@@ -88,11 +84,14 @@ func (a *AesCtrEncryptor) process(in []byte, out []byte, len uint32) error {
 // Encrypt/decrypt an entire input stream, writing to the given output stream
 func (a *AesCtrEncryptor) processStream(in io.Reader, out io.Writer, key []byte, nonce []byte) error {
 	c := NewAesCtrEncryptor(key, nonce)
+	defer c.Destroy()
 	if len(c.key) == 0 {
 		return fmt.Errorf("bad key")
 	}
 	ibuf := make([]byte, 1024)
 	obuf := make([]byte, 1024)
+	defer wipe(ibuf)
+	defer wipe(obuf)
 	for {
 		n, err := in.Read(ibuf)
 		if err != nil {
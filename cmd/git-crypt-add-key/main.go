@@ -1,9 +1,10 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"strings"
 
@@ -14,76 +15,230 @@ import (
 
 var (
 	path   = flag.String("path", "", "Path to repository base")
-	gpgkey = flag.String("key", "", "GPG key file")
-	addkey = flag.String("addkey", "", "GPG public key file to add")
+	gpgkey = flag.String("key", "", "GPG key or keyring file (armored or binary)")
+	addkey = flag.String("addkey", "", "GPG public key or keyring file to add")
 	debug  = flag.Bool("debug", false, "Debug")
+	force  = flag.Bool("force", false, "Add GPG keys even if they fail validation (expired, revoked, weak, ...)")
+
+	ageidentity  = flag.String("age-identity", "", "age identity file used instead of -key to unlock the repository")
+	sshidentity  = flag.String("ssh-identity", "", "SSH private key file used instead of -key to unlock the repository")
+	sshagent     = flag.Bool("ssh-agent", false, "Use ssh-agent ($SSH_AUTH_SOCK) instead of -key to unlock the repository")
+	agerecipient = flag.String("age", "", "age public key to add instead of -addkey")
+	sshkeys      = flag.String("ssh", "", "authorized_keys file whose ssh-ed25519 and ssh-rsa keys are added instead of -addkey")
+	sshself      = flag.Bool("ssh-agent-self", false, "Add the ssh-ed25519 keys held by ssh-agent instead of -addkey")
+	vaultkey     = flag.String("vault-key", "", "Vault transit key used instead of -key to unlock the repository ($VAULT_ADDR, $VAULT_TOKEN)")
+	passlabel    = flag.String("passphrase", "", "Label of a passphrase wrapped key used instead of -key to unlock the repository")
+	passadd      = flag.String("add-passphrase", "", "Label of a passphrase wrapped key to add instead of -addkey")
+	vaultadd     = flag.String("vault", "", "Vault transit key to add instead of -addkey ($VAULT_ADDR, $VAULT_TOKEN)")
+
+	passenv  = flag.String("passphrase-env", "", "Environment variable holding the GPG key passphrase")
+	passfile = flag.String("passphrase-file", "", "File holding the GPG key passphrase")
+	pinentry = flag.String("pinentry", "", "Pinentry program used to ask for the GPG key passphrase")
+	useagent = flag.Bool("agent", false, "Unwrap repository keys with gpg-agent; -key may then be a public key")
+	strict   = flag.Bool("strict", false, "Require wrapped GPG keys to be signed by -key or a key in -trusted")
+	trusted  = flag.String("trusted", "", "Keyring file of keys trusted to sign wrapped GPG keys (implies -strict)")
 )
 
 func main() {
 	flag.Parse()
 
-	if *path == "" || *gpgkey == "" || *addkey == "" {
+	if *path == "" || (*gpgkey == "" && *ageidentity == "" && *sshidentity == "" && !*sshagent && *vaultkey == "" && *passlabel == "") ||
+		(*addkey == "" && *agerecipient == "" && *sshkeys == "" && !*sshself && *vaultadd == "" && *passadd == "") {
 		panic("no path, key, or addkey specified")
 	}
 
-	var keydata *openpgp.Entity
-	var newkeydata *openpgp.Entity
-
-	{
-		rawkeydata, err := os.ReadFile(*gpgkey)
-		if err != nil {
-			panic("unable to ingest GPG key")
-		}
-		keydata, err = gpg.ArmoredKeyIngest(rawkeydata)
-		if err != nil {
-			panic("unable to ingest GPG key")
-		}
+	if *debug {
+		gpg.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
 	}
-
-	{
-		rawkeydata, err := os.ReadFile(*addkey)
+	g := gitcrypt.GitCrypt{Debug: *debug, Prompt: promptFunc()}
+	if *useagent {
+		agent, err := gpg.DialAgent("")
 		if err != nil {
-			panic("unable to ingest public GPG key")
+			panic(err)
 		}
-		newkeydata, err = gpg.ArmoredKeyIngest(rawkeydata)
+		defer agent.Close()
+		g.Agent = agent
+	}
+	if *strict || *trusted != "" {
+		var err error
+		g.Trusted, err = trustedKeys()
 		if err != nil {
-			panic("unable to ingest public GPG key")
+			panic(err)
 		}
 	}
 
-	g := gitcrypt.GitCrypt{Debug: *debug}
-
-	keyring := openpgp.EntityList{keydata}
 	keysPath := *path + string(os.PathSeparator) + ".git-crypt" + string(os.PathSeparator) + "keys"
-	keys, err := g.DecryptRepoKeys(keyring, uint32(0), listKeys(keysPath), keysPath)
+	uw, recipients, err := unlockWrapper(&g, keysPath)
+	if err != nil {
+		panic(err)
+	}
+	keys, err := g.UnwrapRepoKeys(uw, uint32(0), recipients, keysPath)
 	if err != nil {
 		panic(err)
 	}
+	// Keep the keys out of swap where possible, and wipe them on the way out
+	for i := range keys {
+		keys[i].Lock()
+	}
+	defer func() {
+		for i := range keys {
+			keys[i].Destroy()
+		}
+	}()
 
 	if *debug {
-		log.Printf("keys = %#v", keys)
+		for _, k := range keys {
+			log.Printf("unlocked key %q, %d versions", k.KeyName, len(k.Entries))
+		}
 	}
 
-	buf := make([]byte, 0)
-	plainOut := bytes.NewBuffer(buf)
-	err = keys[0].Store(plainOut)
+	w, recipients, err := addWrapper()
 	if err != nil {
 		panic(err)
 	}
+	if ow, ok := w.(*gitcrypt.OpenPGPWrapper); ok {
+		// Sign the new wrapped keys with the adder's key
+		ow.Prompt = g.Prompt
+		ow.Signer = signingKey(uw)
+		if ow.Signer == nil {
+			log.Printf("No GPG secret key to sign with; wrapped keys will be unsigned")
+		}
+	}
+	for _, recipient := range recipients {
+		outfilename, err := g.WrapRepoKey(w, keys[0], recipient, keysPath)
+		if err != nil {
+			panic(err)
+		}
+		if *debug {
+			log.Printf("outfilename = %s", outfilename)
+		}
+	}
+}
 
-	out, err := gpg.Encrypt(plainOut.Bytes(), openpgp.EntityList{newkeydata}, "", "")
-	if err != nil {
-		panic(err)
+// addWrapper returns the KeyWrapper selected on the command line for
+// adding new recipients, along with the recipients to add.
+func addWrapper() (gitcrypt.KeyWrapper, []string, error) {
+	switch {
+	case *agerecipient != "":
+		return gitcrypt.NewAgeWrapper(), []string{*agerecipient}, nil
+	case *sshkeys != "":
+		fp, err := os.Open(*sshkeys)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer fp.Close()
+		recipients, err := gitcrypt.ParseAuthorizedKeys(fp)
+		return gitcrypt.NewSSHWrapper(), recipients, err
+	case *sshself:
+		w, _, err := gitcrypt.NewSSHAgentWrapperFromSocket("")
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, w.Recipients(), nil
+	case *passadd != "":
+		w := gitcrypt.NewPassphraseWrapper(*passadd, promptFunc())
+		return w, w.Recipients(), nil
+	case *vaultadd != "":
+		w, err := gitcrypt.NewVaultWrapperFromEnv(*vaultadd)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, w.Recipients(), nil
+	default:
+		newkeys, err := gpg.ReadKeyRingFile(*addkey)
+		if err != nil {
+			return nil, nil, errors.New("unable to ingest public GPG key")
+		}
+		invalid := false
+		for _, e := range newkeys {
+			err = gpg.ValidateRecipient(e, nil)
+			if err != nil {
+				log.Print(err.Error())
+				invalid = true
+			}
+		}
+		if invalid && !*force {
+			return nil, nil, errors.New("refusing to add invalid GPG keys; use -force to override")
+		}
+		// Every key in the file is added
+		w := gitcrypt.NewOpenPGPWrapper(newkeys)
+		return w, w.Recipients(), nil
 	}
+}
 
-	outfilename := keysPath + string(os.PathSeparator) + "default" + string(os.PathSeparator) + "0" +
-		string(os.PathSeparator) + gpg.Fingerprint(newkeydata) + ".gpg"
-	if *debug {
-		log.Printf("outfilename = %s", outfilename)
+func unlockWrapper(g *gitcrypt.GitCrypt, keysPath string) (gitcrypt.KeyWrapper, []string, error) {
+	switch {
+	case *ageidentity != "":
+		w, err := gitcrypt.NewAgeWrapperFromFile(*ageidentity)
+		return w, nil, err
+	case *sshidentity != "":
+		w, err := gitcrypt.NewSSHWrapperFromFile(*sshidentity, g.Prompt)
+		return w, nil, err
+	case *sshagent:
+		// The agent connection is left open until the process exits
+		w, _, err := gitcrypt.NewSSHAgentWrapperFromSocket("")
+		return w, nil, err
+	case *passlabel != "":
+		return gitcrypt.NewPassphraseWrapper(*passlabel, g.Prompt), nil, nil
+	case *vaultkey != "":
+		w, err := gitcrypt.NewVaultWrapperFromEnv(*vaultkey)
+		return w, nil, err
+	default:
+		keyring, err := gpg.ReadKeyRingFile(*gpgkey)
+		if err != nil {
+			return nil, nil, errors.New("unable to ingest GPG key")
+		}
+		w := &gitcrypt.OpenPGPWrapper{Keyring: keyring, Prompt: g.Prompt, Agent: g.Agent, Trusted: g.Trusted}
+		return w, listKeys(keysPath), nil
 	}
-	err = os.WriteFile(outfilename, out, 0600)
-	if err != nil {
-		panic(err)
+}
+
+// signingKey returns the secret key used to unlock the repository, if the
+// repository was unlocked with one.
+func signingKey(uw gitcrypt.KeyWrapper) *openpgp.Entity {
+	ow, ok := uw.(*gitcrypt.OpenPGPWrapper)
+	if !ok || ow.Agent != nil {
+		return nil
+	}
+	for _, e := range ow.Keyring {
+		if e.PrivateKey != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// trustedKeys returns the keys trusted to sign wrapped GPG keys: those in
+// -key and -trusted.
+func trustedKeys() (openpgp.EntityList, error) {
+	lists := make([]openpgp.EntityList, 0)
+	for _, file := range []string{*gpgkey, *trusted} {
+		if file == "" {
+			continue
+		}
+		el, err := gpg.ReadKeyRingFile(file)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, el)
+	}
+	el := gpg.MergeKeyRings(lists...)
+	if len(el) == 0 {
+		return nil, errors.New("strict mode needs trusted keys in -key or -trusted")
+	}
+	return el, nil
+}
+
+func promptFunc() gpg.PromptFunc {
+	switch {
+	case *passenv != "":
+		return gpg.EnvPrompt(*passenv)
+	case *passfile != "":
+		return gpg.FilePrompt(*passfile)
+	case *pinentry != "":
+		return gpg.PinentryPrompt(*pinentry)
+	default:
+		return gpg.TerminalPrompt()
 	}
 }
 
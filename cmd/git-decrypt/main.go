@@ -3,9 +3,11 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"io/fs"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,37 +19,112 @@ import (
 
 var (
 	path   = flag.String("path", "", "Path to repository base")
-	gpgkey = flag.String("key", "", "GPG key file")
+	gpgkey = flag.String("key", "", "GPG key or keyring file (armored or binary)")
 	debug  = flag.Bool("debug", false, "Debug")
+
+	ageidentity = flag.String("age-identity", "", "age identity file used instead of -key to unlock the repository")
+	sshidentity = flag.String("ssh-identity", "", "SSH private key file used instead of -key to unlock the repository")
+	sshagent    = flag.Bool("ssh-agent", false, "Use ssh-agent ($SSH_AUTH_SOCK) instead of -key to unlock the repository")
+	passlabel   = flag.String("passphrase", "", "Label of a passphrase wrapped key used instead of -key to unlock the repository")
+	keyhelper   = flag.String("keyhelper", "", "Key helper program used instead of -key; defaults to git config gitcrypt.keyhelper")
+	cache       = flag.Bool("cache", false, "Get and keep the unlocked key in the go-git-crypt key agent")
+	cachettl    = flag.Duration("cache-ttl", 0, "How long the key agent holds the unlocked key (agent default if zero)")
+	vaultkey    = flag.String("vault-key", "", "Vault transit key used instead of -key to unlock the repository ($VAULT_ADDR, $VAULT_TOKEN)")
+
+	passenv  = flag.String("passphrase-env", "", "Environment variable holding the GPG key passphrase")
+	passfile = flag.String("passphrase-file", "", "File holding the GPG key passphrase")
+	pinentry = flag.String("pinentry", "", "Pinentry program used to ask for the GPG key passphrase")
+	useagent = flag.Bool("agent", false, "Unwrap repository keys with gpg-agent; -key may then be a public key")
+	strict   = flag.Bool("strict", false, "Require wrapped GPG keys to be signed by -key or a key in -trusted")
+	trusted  = flag.String("trusted", "", "Keyring file of keys trusted to sign wrapped GPG keys (implies -strict)")
 )
 
 func main() {
 	flag.Parse()
 
-	if *path == "" || *gpgkey == "" {
-		panic("no path or key specified")
+	if *path == "" {
+		panic("no path specified")
 	}
+	useHelper := *gpgkey == "" && *ageidentity == "" && *sshidentity == "" && !*sshagent && *vaultkey == "" && *passlabel == ""
 
-	rawkeydata, err := os.ReadFile(*gpgkey)
-	if err != nil {
-		panic("unable to ingest GPG key")
+	if *debug {
+		gpg.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
 	}
-	keydata, err := gpg.ArmoredKeyIngest(rawkeydata)
-	if err != nil {
-		panic("unable to ingest GPG key")
+	g := gitcrypt.GitCrypt{Debug: *debug, Prompt: promptFunc(), KeyHelper: *keyhelper}
+	if *useagent {
+		agent, err := gpg.DialAgent("")
+		if err != nil {
+			panic(err)
+		}
+		defer agent.Close()
+		g.Agent = agent
+	}
+	if *strict || *trusted != "" {
+		var err error
+		g.Trusted, err = trustedKeys()
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	g := gitcrypt.GitCrypt{Debug: *debug}
-
-	keyring := openpgp.EntityList{keydata}
-	keysPath := *path + string(os.PathSeparator) + ".git-crypt" + string(os.PathSeparator) + "keys"
-	keys, err := g.DecryptRepoKeys(keyring, uint32(0), listKeys(keysPath), keysPath)
+	var keys []gitcrypt.Key
+	var cacheClient *gitcrypt.KeyAgentClient
+	repoPath, err := filepath.Abs(*path)
 	if err != nil {
 		panic(err)
 	}
+	if *cache {
+		cacheClient = gitcrypt.NewKeyAgentClient("")
+		key, err := cacheClient.Get(repoPath, "")
+		if err == nil {
+			keys = []gitcrypt.Key{key}
+		} else if !errors.Is(err, gitcrypt.ErrKeyAgentNoKey) {
+			log.Printf("Key agent unavailable: %s", err.Error())
+			cacheClient = nil
+		}
+	}
+	cached := len(keys) > 0
+	switch {
+	case cached:
+		// Unlocked by the key agent
+	case useHelper:
+		// Without an explicit key, fall back to the key helper
+		key, err := g.KeyFromHelper(*path, "", uint32(0))
+		if err != nil {
+			panic(err)
+		}
+		keys = []gitcrypt.Key{key}
+	default:
+		keysPath := *path + string(os.PathSeparator) + ".git-crypt" + string(os.PathSeparator) + "keys"
+		uw, recipients, err := unlockWrapper(&g, keysPath)
+		if err != nil {
+			panic(err)
+		}
+		keys, err = g.UnwrapRepoKeys(uw, uint32(0), recipients, keysPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+	// Keep the keys out of swap where possible, and wipe them on the way out
+	for i := range keys {
+		keys[i].Lock()
+	}
+	defer func() {
+		for i := range keys {
+			keys[i].Destroy()
+		}
+	}()
+	if cacheClient != nil && !cached {
+		err = cacheClient.Put(repoPath, keys[0], *cachettl)
+		if err != nil {
+			log.Printf("Unable to cache key in key agent: %s", err.Error())
+		}
+	}
 
 	if *debug {
-		log.Printf("keys = %#v", keys)
+		for _, k := range keys {
+			log.Printf("unlocked key %q, %d versions", k.KeyName, len(k.Entries))
+		}
 	}
 
 	err = filepath.WalkDir(*path, func(path string, d fs.DirEntry, err error) error {
@@ -99,6 +176,69 @@ func main() {
 	}
 }
 
+// unlockWrapper returns the KeyWrapper selected on the command line for
+// unlocking the repository, along with the recipients to try.
+func unlockWrapper(g *gitcrypt.GitCrypt, keysPath string) (gitcrypt.KeyWrapper, []string, error) {
+	switch {
+	case *ageidentity != "":
+		w, err := gitcrypt.NewAgeWrapperFromFile(*ageidentity)
+		return w, nil, err
+	case *sshidentity != "":
+		w, err := gitcrypt.NewSSHWrapperFromFile(*sshidentity, g.Prompt)
+		return w, nil, err
+	case *sshagent:
+		// The agent connection is left open until the process exits
+		w, _, err := gitcrypt.NewSSHAgentWrapperFromSocket("")
+		return w, nil, err
+	case *passlabel != "":
+		return gitcrypt.NewPassphraseWrapper(*passlabel, g.Prompt), nil, nil
+	case *vaultkey != "":
+		w, err := gitcrypt.NewVaultWrapperFromEnv(*vaultkey)
+		return w, nil, err
+	default:
+		keyring, err := gpg.ReadKeyRingFile(*gpgkey)
+		if err != nil {
+			return nil, nil, errors.New("unable to ingest GPG key")
+		}
+		w := &gitcrypt.OpenPGPWrapper{Keyring: keyring, Prompt: g.Prompt, Agent: g.Agent, Trusted: g.Trusted}
+		return w, listKeys(keysPath), nil
+	}
+}
+
+// trustedKeys returns the keys trusted to sign wrapped GPG keys: those in
+// -key and -trusted.
+func trustedKeys() (openpgp.EntityList, error) {
+	lists := make([]openpgp.EntityList, 0)
+	for _, file := range []string{*gpgkey, *trusted} {
+		if file == "" {
+			continue
+		}
+		el, err := gpg.ReadKeyRingFile(file)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, el)
+	}
+	el := gpg.MergeKeyRings(lists...)
+	if len(el) == 0 {
+		return nil, errors.New("strict mode needs trusted keys in -key or -trusted")
+	}
+	return el, nil
+}
+
+func promptFunc() gpg.PromptFunc {
+	switch {
+	case *passenv != "":
+		return gpg.EnvPrompt(*passenv)
+	case *passfile != "":
+		return gpg.FilePrompt(*passfile)
+	case *pinentry != "":
+		return gpg.PinentryPrompt(*pinentry)
+	default:
+		return gpg.TerminalPrompt()
+	}
+}
+
 func listKeys(keysPath string) []string {
 	keys := make([]string, 0)
 	lookin := keysPath + string(os.PathSeparator) + "default" + string(os.PathSeparator) + "0"
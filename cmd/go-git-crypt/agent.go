@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+)
+
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	socket := fs.String("socket", gitcrypt.KeyAgentSocketPath(), "Key agent socket path")
+	ttl := fs.Duration("ttl", gitcrypt.DefaultKeyAgentTTL, "How long keys are held unless added with their own TTL")
+	fs.Parse(args)
+
+	l, err := gitcrypt.ListenKeyAgent(*socket)
+	if err != nil {
+		return err
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	log.Printf("Key agent listening on %s (ttl %s)", *socket, ttl.String())
+	agent := gitcrypt.NewKeyAgent(*ttl)
+	agent.Logger = slog.Default()
+	err = agent.Serve(l)
+	os.Remove(*socket)
+	return err
+}
+
+func runForget(args []string) error {
+	fs := flag.NewFlagSet("forget", flag.ExitOnError)
+	socket := fs.String("socket", gitcrypt.KeyAgentSocketPath(), "Key agent socket path")
+	path := fs.String("path", "", "Path to repository base")
+	key := fs.String("key", "", "Key name (default key if empty)")
+	all := fs.Bool("all", false, "Forget the keys of every repository, leaving their diff caches to be cleared with diff -clear-cache")
+	fs.Parse(args)
+
+	c := gitcrypt.NewKeyAgentClient(*socket)
+	if *all {
+		return c.ForgetAll()
+	}
+	if *path == "" {
+		return errors.New("no path specified")
+	}
+	abs, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+	err = c.Forget(abs, *key)
+	if err != nil {
+		return err
+	}
+	// Drop plain text decrypted while the key was held
+	cache, err := (&gitcrypt.GitCrypt{}).OpenDiffCache(abs)
+	if err != nil {
+		return err
+	}
+	return cache.Clear()
+}
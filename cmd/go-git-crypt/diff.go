@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+)
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	keyfiles := fs.String("keyfile", "", "Comma separated exported (unlocked) repository key files")
+	nocache := fs.Bool("no-cache", false, "Do not use or fill the diff cache")
+	cacheSize := fs.Int64("cache-size", gitcrypt.DefaultDiffCacheSize, "Bound of the diff cache, in bytes")
+	redact := fs.Bool("redact", false, "Show .env, YAML and JSON files with their values redacted, and summarise others")
+	format := fs.String("format", "", "Format used with -redact (env, yaml or json) instead of guessing from the file name")
+	clearCache := fs.Bool("clear-cache", false, "Remove every entry from the diff cache, which holds decrypted text until cleared, and exit")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: go-git-crypt diff [flags] <file>")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Use as a git textconv filter, with diff=git-crypt in .gitattributes and")
+		fmt.Fprintln(fs.Output(), "  git config diff.git-crypt.textconv 'go-git-crypt diff'")
+		fmt.Fprintln(fs.Output(), "or, to produce review artifacts without secrets,")
+		fmt.Fprintln(fs.Output(), "  git -c diff.git-crypt.textconv='go-git-crypt diff -redact' diff")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// textconv filters run at the top of the working tree
+	g := gitcrypt.GitCrypt{}
+	repo, err := filepath.Abs(".")
+	if err != nil {
+		return err
+	}
+	var cache *gitcrypt.DiffCache
+	if !*nocache || *clearCache {
+		cache, err = g.OpenDiffCache(repo)
+		if err != nil {
+			return err
+		}
+		cache.MaxBytes = *cacheSize
+	}
+	if *clearCache {
+		return cache.Clear()
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a file")
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out := bufio.NewWriter(os.Stdout)
+	keys, err := allUnlockedKeys(&g, repo, *keyfiles)
+	if err != nil {
+		return err
+	}
+	defer destroyKeys(keys)
+	if len(keys) == 0 && cache != nil {
+		// Locked, so drop any plain text left from when it was not
+		err = cache.Clear()
+		if err != nil {
+			return err
+		}
+	}
+	if *redact {
+		// git names textconv input after the file, keeping its extension
+		name := fs.Arg(0)
+		if *format != "" {
+			name = "." + *format
+		}
+		err = g.RedactedTextConv(keys, name, in, out, cache)
+	} else {
+		err = g.TextConv(keys, in, out, cache)
+	}
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
@@ -0,0 +1,26 @@
+module github.com/jbuchbinder/go-git-crypt/cmd/go-git-crypt
+
+go 1.23.0
+
+toolchain go1.24.3
+
+replace (
+	github.com/jbuchbinder/go-git-crypt => ../..
+	github.com/jbuchbinder/go-git-crypt/gpg => ../../gpg
+)
+
+require (
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/jbuchbinder/go-git-crypt v0.0.0-20250212140507-1b2044cb2630
+	github.com/jbuchbinder/go-git-crypt/gpg v0.0.0-20250212141212-325ebd1e616b
+)
+
+require (
+	filippo.io/age v1.2.1 // indirect
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/cloudflare/circl v1.6.1 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/term v0.34.0 // indirect
+	golang.org/x/tools v0.36.0 // indirect
+)
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+)
+
+// hookCommands are the commands which may be installed as git hooks.
+var hookCommands = map[string]bool{
+	"pre-commit":  true,
+	"pre-receive": true,
+}
+
+func runPreCommit(args []string) error {
+	fs := flag.NewFlagSet("pre-commit", flag.ExitOnError)
+	path := fs.String("path", ".", "Path to repository base")
+	fs.Parse(args)
+
+	g := gitcrypt.GitCrypt{}
+	repo, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+	staged, err := g.CheckStaged(repo)
+	if err != nil {
+		return err
+	}
+	if len(staged) == 0 {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "These files should be encrypted, but are staged in plain text:")
+	for _, f := range staged {
+		fmt.Fprintf(os.Stderr, "\t%s\n", f.Path)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "The git-crypt filter is probably not configured in this clone. Unlock the")
+	fmt.Fprintln(os.Stderr, "repository, then stage them again with:")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "\tgit rm --cached -- <path> && git add -- <path>")
+	return fmt.Errorf("%d files staged in plain text", len(staged))
+}
+
+func runPreReceive(args []string) error {
+	fs := flag.NewFlagSet("pre-receive", flag.ExitOnError)
+	path := fs.String("path", ".", "Path to repository")
+	fs.Parse(args)
+
+	g := gitcrypt.GitCrypt{}
+	repo, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+	policy, err := g.ReceivePolicyFromConfig(repo)
+	if err != nil {
+		return err
+	}
+	updates, err := gitcrypt.ReadReceiveUpdates(os.Stdin)
+	if err != nil {
+		return err
+	}
+	violations, err := g.CheckReceive(repo, updates, policy)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, v := range violations {
+		fmt.Fprintf(os.Stderr, "%s %.12s %s: %s\n", v.Ref, v.Commit, v.Path, v.Reason)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Encrypt the files and rewrite the pushed commits, or ask an administrator to")
+	fmt.Fprintln(os.Stderr, "allow them with gitcrypt.receive.allowPath, gitcrypt.receive.allowRef or")
+	fmt.Fprintln(os.Stderr, "gitcrypt.receive.allowRecipientReplacement.")
+	return fmt.Errorf("push rejected: %d problems", len(violations))
+}
+
+func runInstallHooks(args []string) error {
+	fs := flag.NewFlagSet("install-hooks", flag.ExitOnError)
+	path := fs.String("path", ".", "Path to repository base")
+	force := fs.Bool("force", false, "Replace existing hooks not installed by go-git-crypt")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: go-git-crypt install-hooks [flags] [hook...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	hooks := fs.Args()
+	if len(hooks) == 0 {
+		hooks = []string{"pre-commit"}
+	}
+	g := gitcrypt.GitCrypt{}
+	repo, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if !hookCommands[hook] {
+			return fmt.Errorf("go-git-crypt has no %s hook", hook)
+		}
+		hookPath, err := g.InstallHook(repo, hook, *force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s\n", hookPath)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+)
+
+// unlockedKeys returns the unlocked keys for commands run by git, which
+// cannot prompt: the exported key files in keyfiles if given, otherwise the
+// key held by the key agent, otherwise the key from the key helper.
+func unlockedKeys(g *gitcrypt.GitCrypt, repo string, keyName string, keyfiles string) ([]gitcrypt.Key, error) {
+	keys := make([]gitcrypt.Key, 0)
+	if keyfiles != "" {
+		for _, fn := range strings.Split(keyfiles, ",") {
+			key, err := g.KeyFromFile(fn)
+			if err != nil {
+				destroyKeys(keys)
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		holdKeys(keys)
+		return keys, nil
+	}
+
+	key, agentErr := gitcrypt.NewKeyAgentClient("").Get(repo, keyName)
+	if agentErr != nil {
+		var err error
+		key, err = g.KeyFromHelper(repo, keyName, 0)
+		if err != nil {
+			return nil, fmt.Errorf("repository is locked (key agent: %s; %s)", agentErr.Error(), err.Error())
+		}
+	}
+	keys = append(keys, key)
+	holdKeys(keys)
+	return keys, nil
+}
+
+// holdKeys locks unlocked keys into memory where possible, so they are
+// never written to swap. Keys work whether or not this succeeds.
+func holdKeys(keys []gitcrypt.Key) {
+	for i := range keys {
+		keys[i].Lock()
+	}
+}
+
+// destroyKeys wipes unlocked keys once a command is done with them.
+func destroyKeys(keys []gitcrypt.Key) {
+	for i := range keys {
+		keys[i].Destroy()
+	}
+}
+
+// allUnlockedKeys returns every unlocked key available to commands run by
+// git, as unlockedKeys, for each key in .git-crypt/keys. Keys which are not
+// available from the key agent or helper are skipped, but explicitly given
+// key files must all be readable.
+func allUnlockedKeys(g *gitcrypt.GitCrypt, repo string, keyfiles string) ([]gitcrypt.Key, error) {
+	if keyfiles != "" {
+		return unlockedKeys(g, repo, "", keyfiles)
+	}
+	keys := make([]gitcrypt.Key, 0)
+	dirents, _ := os.ReadDir(filepath.Join(repo, ".git-crypt", "keys"))
+	for _, d := range dirents {
+		keyName := d.Name()
+		if keyName == "default" {
+			keyName = ""
+		}
+		unlocked, err := unlockedKeys(g, repo, keyName, "")
+		if err == nil {
+			keys = append(keys, unlocked...)
+		}
+	}
+	return keys, nil
+}
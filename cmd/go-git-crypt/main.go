@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a go-git-crypt subcommand, which parses its own flags from
+// args.
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"agent":           {runAgent, "Hold unlocked repository keys in memory"},
+	"decrypt-share":   {runDecryptShare, "Decrypt your key shares to hand to whoever unlocks"},
+	"diff":            {runDiff, "Show encrypted files as text, as a git textconv filter"},
+	"forget":          {runForget, "Remove repository keys from the key agent"},
+	"install-hooks":   {runInstallHooks, "Install git hooks running go-git-crypt"},
+	"merge":           {runMerge, "Three-way merge driver for encrypted files"},
+	"pre-commit":      {runPreCommit, "Refuse commits staging plain text into encrypted paths"},
+	"pre-receive":     {runPreReceive, "Reject pushes with plain text secrets or replaced recipients"},
+	"rewrite-history": {runRewriteHistory, "Rewrite history to encrypt or purge files"},
+	"scan-history":    {runScanHistory, "Find files committed in plain text before encryption was configured"},
+	"split-key":       {runSplitKey, "Split a repository key into shares for break-glass unlock"},
+	"sync":            {runSync, "Bring wrapped keys in line with .git-crypt/recipients"},
+	"unlock-shares":   {runUnlockShares, "Unlock a repository key from a threshold of key shares"},
+	"verify":          {runVerify, "Check the integrity of every encrypted file"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "go-git-crypt: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "go-git-crypt %s: %s\n", os.Args[1], err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: go-git-crypt <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+)
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	keyfiles := fs.String("keyfile", "", "Comma separated exported (unlocked) repository key files")
+	markerSize := fs.Int("marker-size", 7, "Conflict marker size (%L)")
+	name := fs.String("name", "", "Path of the file being merged (%P), used to choose the key")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: go-git-crypt merge [flags] <base> <ours> <theirs>")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Use as a git merge driver, with merge=git-crypt in .gitattributes and")
+		fmt.Fprintln(fs.Output(), "  git config merge.git-crypt.driver 'go-git-crypt merge -marker-size %L -name %P %O %A %B'")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return errors.New("expected base, ours and theirs")
+	}
+
+	// Merge drivers run at the top of the working tree
+	g := gitcrypt.GitCrypt{}
+	repo, err := filepath.Abs(".")
+	if err != nil {
+		return err
+	}
+	keyName := ""
+	if *name != "" {
+		keyName, _, err = g.KeyNameForPath(repo, *name)
+		if err != nil {
+			return err
+		}
+	}
+	keys, err := unlockedKeys(&g, repo, keyName, *keyfiles)
+	if err != nil {
+		return err
+	}
+	defer destroyKeys(keys)
+
+	versions := make([][]byte, 3)
+	for i, fn := range fs.Args() {
+		versions[i], err = os.ReadFile(fn)
+		if err != nil {
+			return err
+		}
+	}
+	label := *name
+	if label == "" {
+		label = fs.Arg(1)
+	}
+	merged, conflicts, err := g.Merge(keys, versions[0], versions[1], versions[2], gitcrypt.MergeOptions{
+		MarkerSize:  *markerSize,
+		OursLabel:   "ours:" + label,
+		BaseLabel:   "base:" + label,
+		TheirsLabel: "theirs:" + label,
+	})
+	if err != nil {
+		return err
+	}
+	// git takes the result from ours
+	err = os.WriteFile(fs.Arg(1), merged, 0644)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		return errors.New("conflicts in " + label)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+func runRewriteHistory(args []string) error {
+	fs := flag.NewFlagSet("rewrite-history", flag.ExitOnError)
+	path := fs.String("path", ".", "Path to repository base")
+	gpgkey := fs.String("key", "", "GPG secret key file used to unlock the repository keys")
+	keyfiles := fs.String("keyfile", "", "Comma separated exported (unlocked) repository key files")
+	keyname := fs.String("key-name", "", "Key used for files selected by -paths")
+	version := fs.Int("version", -1, "Key version to encrypt with (the latest of each key if negative)")
+	paths := fs.String("paths", "", "Comma separated path patterns to rewrite instead of those in .gitattributes")
+	purge := fs.Bool("purge", false, "Drop the selected files instead of encrypting them")
+	mapfile := fs.String("map", "", "Write the old to new commit mapping to this file instead of standard output")
+	passenv := fs.String("passphrase-env", "", "Environment variable holding the GPG key passphrase")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: go-git-crypt rewrite-history [flags] [ref...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	g := gitcrypt.GitCrypt{Prompt: gpg.TerminalPrompt()}
+	if *passenv != "" {
+		g.Prompt = gpg.EnvPrompt(*passenv)
+	}
+	repo, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+
+	opts := gitcrypt.RewriteOptions{
+		Refs:    fs.Args(),
+		KeyName: *keyname,
+		Purge:   *purge,
+	}
+	if *paths != "" {
+		opts.Paths = strings.Split(*paths, ",")
+	}
+	defer func() { destroyKeys(opts.Keys) }()
+	if *gpgkey != "" {
+		secretKeys, err := gpg.ReadKeyRingFile(*gpgkey)
+		if err != nil {
+			return err
+		}
+		keys, err := unlockAllVersions(&g, secretKeys, filepath.Join(repo, ".git-crypt", "keys"))
+		opts.Keys = mergeKeys(opts.Keys, keys)
+		if err != nil {
+			return err
+		}
+	}
+	if *keyfiles != "" {
+		for _, fn := range strings.Split(*keyfiles, ",") {
+			key, err := g.KeyFromFile(fn)
+			if err != nil {
+				return err
+			}
+			opts.Keys = mergeKeys(opts.Keys, []gitcrypt.Key{key})
+		}
+	}
+	if len(opts.Keys) == 0 && !opts.Purge {
+		return errors.New("no keys specified; use -key or -keyfile")
+	}
+	holdKeys(opts.Keys)
+	if *version >= 0 {
+		opts.KeyVersion = uint32(*version)
+	} else {
+		opts.KeyVersions = make(map[string]uint32)
+		for _, key := range opts.Keys {
+			for _, e := range key.Entries {
+				if e.Version >= opts.KeyVersions[key.KeyName] {
+					opts.KeyVersions[key.KeyName] = e.Version
+				}
+			}
+		}
+	}
+
+	mapping, err := g.RewriteHistory(repo, opts)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *mapfile != "" {
+		f, err := os.Create(*mapfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	old := make([]string, 0, len(mapping))
+	for commit := range mapping {
+		old = append(old, commit)
+	}
+	sort.Strings(old)
+	for _, commit := range old {
+		fmt.Fprintf(out, "%s %s\n", commit, mapping[commit])
+	}
+	fmt.Fprintln(os.Stderr, "History rewritten; run \"git reset --hard\" to update the working tree")
+	return nil
+}
+
+// unlockAllVersions unlocks every version of every key in keysPath, to
+// re-encrypt files under older ones. Versions which do not unlock are
+// reported and skipped, but a key unlocked at all must unlock at its latest
+// version, so that files are not re-encrypted under an older version which
+// removed recipients can still read.
+func unlockAllVersions(g *gitcrypt.GitCrypt, secretKeys openpgp.EntityList, keysPath string) ([]gitcrypt.Key, error) {
+	dirents, err := os.ReadDir(keysPath)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]gitcrypt.Key, 0)
+	for _, d := range dirents {
+		if !d.IsDir() {
+			continue
+		}
+		keyName := d.Name()
+		if keyName == "default" {
+			keyName = ""
+		}
+		latest, err := g.LatestKeyVersion(keysPath, keyName)
+		if err != nil {
+			return keys, err
+		}
+		unlocked := false
+		for v := uint32(0); v <= latest; v++ {
+			key, err := g.DecryptRepoKey(secretKeys, keyName, v, nil, keysPath)
+			if err == nil {
+				keys = mergeKeys(keys, []gitcrypt.Key{key})
+				unlocked = true
+				continue
+			}
+			if v == latest && unlocked {
+				return keys, fmt.Errorf("unable to unlock the latest version, %d, of key %q: %w", v, d.Name(), err)
+			}
+			fmt.Fprintf(os.Stderr, "Unable to unlock key %q version %d, skipping: %s\n", d.Name(), v, err.Error())
+		}
+	}
+	return keys, nil
+}
+
+// mergeKeys adds the entries of keys to those with the same name in into.
+// Entries for versions into already has are destroyed.
+func mergeKeys(into []gitcrypt.Key, keys []gitcrypt.Key) []gitcrypt.Key {
+	for _, key := range keys {
+		merged := false
+		for i := range into {
+			if into[i].KeyName != key.KeyName {
+				continue
+			}
+			for j := range key.Entries {
+				if _, err := into[i].Get(key.Entries[j].Version); err != nil {
+					into[i].Entries = append(into[i].Entries, key.Entries[j])
+				} else {
+					key.Entries[j].Destroy()
+				}
+			}
+			merged = true
+		}
+		if !merged {
+			into = append(into, key)
+		}
+	}
+	return into
+}
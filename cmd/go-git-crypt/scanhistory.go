@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+)
+
+func runScanHistory(args []string) error {
+	fs := flag.NewFlagSet("scan-history", flag.ExitOnError)
+	path := fs.String("path", ".", "Path to repository base")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: go-git-crypt scan-history [flags] [ref...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	g := gitcrypt.GitCrypt{}
+	repo, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+	leaks, err := g.ScanHistory(repo, fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, l := range leaks {
+		fmt.Printf("%s %s (%s)\n", l.Commit, l.Path, l.Author)
+	}
+	if len(leaks) > 0 {
+		return fmt.Errorf("%d files committed in plain text; rotate the secrets they hold", len(leaks))
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+// shareFlags are the flags common to the key share commands.
+type shareFlags struct {
+	path    *string
+	gpgkey  *string
+	keyName *string
+	version *int
+	passenv *string
+}
+
+func newShareFlags(fs *flag.FlagSet, keyUsage string) shareFlags {
+	return shareFlags{
+		path:    fs.String("path", ".", "Path to repository base"),
+		gpgkey:  fs.String("key", "", keyUsage),
+		keyName: fs.String("key-name", "", "Key name (default key if empty)"),
+		version: fs.Int("version", -1, "Key version (latest if negative)"),
+		passenv: fs.String("passphrase-env", "", "Environment variable holding the GPG key passphrase"),
+	}
+}
+
+// open returns the GitCrypt, repository, keys path and key version the
+// flags select.
+func (f shareFlags) open() (*gitcrypt.GitCrypt, string, string, uint32, error) {
+	g := &gitcrypt.GitCrypt{Prompt: gpg.TerminalPrompt()}
+	if *f.passenv != "" {
+		g.Prompt = gpg.EnvPrompt(*f.passenv)
+	}
+	repo, err := filepath.Abs(*f.path)
+	if err != nil {
+		return nil, "", "", 0, err
+	}
+	keysPath := filepath.Join(repo, ".git-crypt", "keys")
+	if *f.version >= 0 {
+		return g, repo, keysPath, uint32(*f.version), nil
+	}
+	latest, err := g.LatestKeyVersion(keysPath, *f.keyName)
+	return g, repo, keysPath, latest, err
+}
+
+func runSplitKey(args []string) error {
+	fs := flag.NewFlagSet("split-key", flag.ExitOnError)
+	sf := newShareFlags(fs, "GPG secret key file used to unlock the repository key")
+	keyfile := fs.String("keyfile", "", "Exported (unlocked) repository key file, instead of -key")
+	custodians := fs.String("custodians", "", "Keyring file holding the public keys of the share custodians")
+	threshold := fs.Int("threshold", 2, "Number of shares needed to unlock")
+	fs.Parse(args)
+
+	if *custodians == "" {
+		return errors.New("no custodians specified; use -custodians")
+	}
+	g, _, keysPath, version, err := sf.open()
+	if err != nil {
+		return err
+	}
+	recipients, err := gpg.ReadKeyRingFile(*custodians)
+	if err != nil {
+		return err
+	}
+
+	var key gitcrypt.Key
+	switch {
+	case *keyfile != "":
+		key, err = g.KeyFromFile(*keyfile)
+		if err == nil && key.KeyName != *sf.keyName {
+			err = fmt.Errorf("%s holds key %q, not %q", *keyfile, key.KeyName, *sf.keyName)
+		}
+	case *sf.gpgkey != "":
+		var secretKeys openpgp.EntityList
+		secretKeys, err = gpg.ReadKeyRingFile(*sf.gpgkey)
+		if err == nil {
+			key, err = g.DecryptRepoKey(secretKeys, *sf.keyName, version, nil, keysPath)
+		}
+	default:
+		err = errors.New("no key specified; use -key or -keyfile")
+	}
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	paths, err := g.SplitRepoKey(key, *threshold, recipients, keysPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		fmt.Println(p)
+	}
+	fmt.Fprintf(os.Stderr, "Split into %d shares, %d needed to unlock; commit them to share the key\n", len(paths), *threshold)
+	return nil
+}
+
+func runDecryptShare(args []string) error {
+	fs := flag.NewFlagSet("decrypt-share", flag.ExitOnError)
+	sf := newShareFlags(fs, "GPG secret key file of the custodian")
+	out := fs.String("out", "", "File to write the decrypted shares to, to hand to whoever unlocks")
+	fs.Parse(args)
+
+	if *sf.gpgkey == "" || *out == "" {
+		return errors.New("decrypt-share needs -key and -out")
+	}
+	g, _, keysPath, version, err := sf.open()
+	if err != nil {
+		return err
+	}
+	keyring, err := gpg.ReadKeyRingFile(*sf.gpgkey)
+	if err != nil {
+		return err
+	}
+	shares, err := g.DecryptKeyShares(keyring, *sf.keyName, version, keysPath)
+	if err != nil {
+		return err
+	}
+	defer wipeShares(shares)
+	err = writeShares(*out, shares)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d shares to %s\n", len(shares), *out)
+	return nil
+}
+
+func runUnlockShares(args []string) error {
+	fs := flag.NewFlagSet("unlock-shares", flag.ExitOnError)
+	sf := newShareFlags(fs, "GPG secret key file of a custodian, to decrypt their own shares")
+	sharefiles := fs.String("shares", "", "Comma separated files written by decrypt-share")
+	out := fs.String("out", "", "File to export the unlocked repository key to")
+	agent := fs.Bool("agent", false, "Hand the unlocked repository key to the key agent")
+	ttl := fs.Duration("ttl", 0, "How long the key agent holds the key (agent default if zero)")
+	fs.Parse(args)
+
+	if *out == "" && !*agent {
+		return errors.New("no destination for the unlocked key; use -out or -agent")
+	}
+	g, repo, keysPath, version, err := sf.open()
+	if err != nil {
+		return err
+	}
+	others := make([][]byte, 0)
+	defer func() { wipeShares(others) }()
+	if *sharefiles != "" {
+		for _, fn := range strings.Split(*sharefiles, ",") {
+			shares, err := readShares(fn)
+			if err != nil {
+				return err
+			}
+			others = append(others, shares...)
+		}
+	}
+	var keyring openpgp.EntityList
+	if *sf.gpgkey != "" {
+		keyring, err = gpg.ReadKeyRingFile(*sf.gpgkey)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := g.UnlockWithShares(keyring, *sf.keyName, version, keysPath, others)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	if *agent {
+		err = gitcrypt.NewKeyAgentClient("").Put(repo, key, *ttl)
+		if err != nil {
+			return err
+		}
+	}
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		err = key.Store(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Repository key unlocked from shares")
+	return nil
+}
+
+// writeShares writes decrypted key shares to a new file readable only by
+// its owner, one per line, base64 encoded.
+func writeShares(fn string, shares [][]byte) error {
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	for _, share := range shares {
+		_, err = fmt.Fprintln(f, base64.StdEncoding.EncodeToString(share))
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// readShares reads decrypted key shares written by writeShares.
+func readShares(fn string) ([][]byte, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeShares([][]byte{data})
+	shares := make([][]byte, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		share, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: malformed key share", fn)
+		}
+		shares = append(shares, share)
+	}
+	return shares, scanner.Err()
+}
+
+// wipeShares overwrites decrypted key shares once they are no longer
+// needed.
+func wipeShares(shares [][]byte) {
+	for _, share := range shares {
+		clear(share)
+	}
+}
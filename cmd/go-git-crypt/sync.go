@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	path := fs.String("path", ".", "Path to repository base")
+	gpgkey := fs.String("key", "", "GPG secret key file used to unlock the repository and sign added keys")
+	keyring := fs.String("keyring", "", "Keyring file holding the public keys of recipients given by fingerprint")
+	rotate := fs.Bool("rotate", false, "Rotate to a new key version when recipients are removed")
+	dryrun := fs.Bool("n", false, "Only show what would change")
+	passenv := fs.String("passphrase-env", "", "Environment variable holding the GPG key passphrase")
+	fs.Parse(args)
+
+	g := gitcrypt.GitCrypt{Prompt: gpg.TerminalPrompt()}
+	if *passenv != "" {
+		g.Prompt = gpg.EnvPrompt(*passenv)
+	}
+	repo, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+	policy, err := g.ReadRecipientPolicy(repo)
+	if err != nil {
+		return err
+	}
+
+	var publicKeys openpgp.EntityList
+	if *keyring != "" {
+		publicKeys, err = gpg.ReadKeyRingFile(*keyring)
+		if err != nil {
+			return err
+		}
+	}
+	plans, err := g.PlanSync(policy, repo, publicKeys, time.Now())
+	if err != nil {
+		return err
+	}
+
+	pending := false
+	for _, plan := range plans {
+		name := plan.KeyName
+		if name == "" {
+			name = "default"
+		}
+		for _, e := range plan.Add {
+			fmt.Printf("%s/%d: add %s\n", name, plan.Version, gpg.Fingerprint(e))
+		}
+		for _, fpr := range plan.Remove {
+			fmt.Printf("%s/%d: remove %s\n", name, plan.Version, fpr)
+		}
+		for _, fpr := range plan.Expired {
+			fmt.Printf("%s/%d: %s has expired\n", name, plan.Version, fpr)
+		}
+		if !plan.Empty() {
+			pending = true
+		}
+	}
+	if !pending || *dryrun {
+		return nil
+	}
+
+	if *gpgkey == "" {
+		return errors.New("no key specified to unlock the repository")
+	}
+	secretKeys, err := gpg.ReadKeyRingFile(*gpgkey)
+	if err != nil {
+		return err
+	}
+	w := &gitcrypt.OpenPGPWrapper{Prompt: g.Prompt}
+	for _, e := range secretKeys {
+		if e.PrivateKey != nil {
+			w.Signer = e
+			break
+		}
+	}
+
+	keysPath := filepath.Join(repo, ".git-crypt", "keys")
+	for _, plan := range plans {
+		if plan.Empty() {
+			continue
+		}
+		key, err := g.DecryptRepoKey(secretKeys, plan.KeyName, plan.Version, nil, keysPath)
+		if err != nil {
+			return err
+		}
+		defer key.Destroy()
+		key, err = g.SyncRecipients(plan, key, w, repo, publicKeys, policy, *rotate)
+		if err != nil {
+			return err
+		}
+		latest, _ := key.Latest()
+		if latest.Version != plan.Version {
+			fmt.Printf("Rotated to version %d; re-encrypt files to protect them from removed recipients\n", latest.Version)
+		}
+	}
+	return nil
+}
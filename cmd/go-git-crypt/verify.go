@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	gitcrypt "github.com/jbuchbinder/go-git-crypt"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	path := fs.String("path", ".", "Path to repository base")
+	gpgkey := fs.String("key", "", "GPG secret key file used to unlock the repository keys")
+	keyfiles := fs.String("keyfile", "", "Comma separated exported (unlocked) repository key files")
+	source := fs.String("source", "index", `What to check: "worktree", "index" or a commit`)
+	verbose := fs.Bool("v", false, "Show files which verified correctly")
+	passenv := fs.String("passphrase-env", "", "Environment variable holding the GPG key passphrase")
+	fs.Parse(args)
+
+	g := gitcrypt.GitCrypt{Prompt: gpg.TerminalPrompt()}
+	if *passenv != "" {
+		g.Prompt = gpg.EnvPrompt(*passenv)
+	}
+	repo, err := filepath.Abs(*path)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]gitcrypt.Key, 0)
+	defer func() { destroyKeys(keys) }()
+	if *gpgkey != "" {
+		secretKeys, err := gpg.ReadKeyRingFile(*gpgkey)
+		if err != nil {
+			return err
+		}
+		unlocked, err := g.DecryptRepoKeys(secretKeys, 0, nil, filepath.Join(repo, ".git-crypt", "keys"))
+		if err != nil {
+			return err
+		}
+		keys = append(keys, unlocked...)
+	}
+	if *keyfiles != "" {
+		for _, fn := range strings.Split(*keyfiles, ",") {
+			key, err := g.KeyFromFile(fn)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("no keys specified; use -key or -keyfile")
+	}
+	holdKeys(keys)
+
+	src := *source
+	if src == "worktree" {
+		src = ""
+	}
+	results, err := g.Verify(repo, keys, src)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Status != gitcrypt.VerifyOK || *verbose {
+			fmt.Printf("%s: %s\n", r.Path, r.Status)
+		}
+	}
+	failed := gitcrypt.VerifyFailed(results)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d encrypted files failed verification", len(failed), len(results))
+	}
+	return nil
+}
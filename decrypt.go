@@ -5,12 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"strings"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
-	"github.com/jbuchbinder/go-git-crypt/gpg"
 )
 
 /*
@@ -42,105 +39,12 @@ func (g *GitCrypt) Decrypt(key []byte, data []byte) ([]byte, error) {
 //   - secretKeys: Array of private keys to attempt to decrypt
 //   - keysPath: Root path to the repository key directory (should be $REPOPATH/.git-crypt/keys)
 func (g *GitCrypt) DecryptRepoKey(keyring openpgp.EntityList, keyName string, keyVersion uint32, secretKeys []string, keysPath string) (Key, error) {
-	//var err error
-	keyFile := Key{}
-
-	for _, seckey := range secretKeys {
-		path := keysPath + string(os.PathSeparator)
-		if keyName == "" {
-			path += "default"
-		} else {
-			path += keyName
-		}
-		path += string(os.PathSeparator) + fmt.Sprintf("%d", keyVersion) + string(os.PathSeparator) + seckey + ".gpg"
-
-		if g.fileExists(path) {
-			log.Printf("Decrypting key %v in path %s", keyring, path)
-
-			decryptedContents, err := g.GpgDecryptFromFile(keyring, path)
-			if err != nil {
-				log.Printf("decryption of file %s : %s", path, err.Error())
-				continue
-			}
-			if len(decryptedContents) == 0 {
-				log.Printf("")
-				continue
-			}
-
-			var thisVersionKeyFile Key
-			br := bytes.NewBuffer(decryptedContents)
-			err = thisVersionKeyFile.Load(br)
-			if err != nil {
-				return keyFile, fmt.Errorf("unable to load version key file")
-			}
-			thisVersionEntry, err := thisVersionKeyFile.Get(keyVersion)
-			if err != nil {
-				return keyFile, fmt.Errorf("GPG-encrypted keyfile is malformed because it does not contain expected key version")
-
-			}
-			if strings.Compare(keyName, thisVersionKeyFile.KeyName) != 0 {
-				return keyFile, fmt.Errorf("GPG-encrypted keyfile is malformed because it does not contain expected key name")
-
-			}
-			keyFile.Entries = append(keyFile.Entries, thisVersionEntry)
-			return keyFile, nil
-		}
-	}
-
-	return keyFile, errors.New("no secret keys")
+	return g.UnwrapRepoKey(g.openPGPWrapper(keyring), keyName, keyVersion, secretKeys, keysPath)
 }
 
 // DecryptRepoKeys decrypts all available repository keys, given a GPG key
 func (g *GitCrypt) DecryptRepoKeys(keyring openpgp.EntityList, keyVersion uint32, secretKeys []string, keysPath string) ([]Key, error) {
-	successful := false
-	dirents := make([]string, 0)
-	keyFiles := make([]Key, 0)
-
-	if g.fileExists(keysPath) {
-		if g.Vfs == nil {
-			fp, err := os.Open(keysPath)
-			if err != nil {
-				return keyFiles, err
-			}
-			defer fp.Close()
-			entries, err := fp.ReadDir(0)
-			if err != nil {
-				return keyFiles, err
-			}
-			for _, entry := range entries {
-				dirents = append(dirents, entry.Name())
-			}
-		} else {
-			entries, err := g.Vfs.ReadDir(keysPath)
-			if err != nil {
-				return keyFiles, err
-			}
-			for _, entry := range entries {
-				dirents = append(dirents, entry.Name())
-			}
-		}
-	}
-
-	for _, dirent := range dirents {
-		log.Printf("decryptRepoKeys : %s", dirent)
-		keyName := ""
-		if strings.Compare(dirent, "default") != 0 {
-			if err := validateKeyName(dirent); err != nil {
-				continue
-			}
-			keyName = dirent
-		}
-
-		keyFile, err := g.DecryptRepoKey(keyring, keyName, keyVersion, secretKeys, keysPath)
-		if err == nil {
-			keyFiles = append(keyFiles, keyFile)
-			successful = true
-		}
-	}
-	if !successful {
-		return keyFiles, fmt.Errorf("unsuccessful")
-	}
-	return keyFiles, nil
+	return g.UnwrapRepoKeys(g.openPGPWrapper(keyring), keyVersion, secretKeys, keysPath)
 }
 
 // ReadFileHeaderFromFile fetches the git-crypt file header from an unopened
@@ -153,9 +57,7 @@ func (g *GitCrypt) ReadFileHeaderFromFile(filename string) ([]byte, error) {
 	}
 	defer fp.Close()
 	n, err := fp.Read(header)
-	if g.Debug {
-		log.Printf("readFileHeaderFromFile : read %d bytes : %s", n, header)
-	}
+	g.logger().Debug("read file header", "file", filename, "bytes", n)
 	return header, err
 }
 
@@ -168,16 +70,11 @@ func (g *GitCrypt) ReadFileHeader(fp io.ReadSeekCloser) ([]byte, error) {
 		return header, err
 	}
 	n, err := fp.Read(header)
-	if g.Debug {
-		log.Printf("readFileHeader : read %d bytes : %#v", n, header)
-	}
+	g.logger().Debug("read file header", "bytes", n)
 	if err != nil {
 		return header, err
 	}
-	pos, err := fp.Seek(10+aesEncryptorNonceLen, io.SeekStart)
-	if g.Debug {
-		log.Printf("readFileHeader : seek'd to %d: err = %#v", pos, err)
-	}
+	_, err = fp.Seek(10+aesEncryptorNonceLen, io.SeekStart)
 	return header, err
 }
 
@@ -192,7 +89,7 @@ func (g *GitCrypt) IsGitCrypted(fn string) bool {
 	}
 	if err != nil {
 		// If we can't open the file, skip git-crypting
-		log.Printf("ERR: %s", err.Error())
+		g.logger().Warn("unable to check file", "file", fn, "err", err)
 		return false
 	}
 
@@ -203,56 +100,54 @@ func (g *GitCrypt) IsGitCrypted(fn string) bool {
 		fp, err := g.Vfs.Open(fn)
 		if err != nil {
 			// If we can't open the file, skip git-crypting
-			log.Printf("ERR: %s", err.Error())
+			g.logger().Warn("unable to check file", "file", fn, "err", err)
 			return false
 		}
 		defer fp.Close()
 		_, err = fp.Seek(0, io.SeekStart)
 		if err != nil {
-			log.Printf("ERR: %s", err.Error())
+			g.logger().Warn("unable to check file", "file", fn, "err", err)
 			return false
 		}
 		n, err = fp.Read(b)
 		if err != nil {
-			log.Printf("ERR: %s", err.Error())
+			g.logger().Warn("unable to check file", "file", fn, "err", err)
 			return false
 		}
 	} else {
 		fp, err := os.Open(fn)
 		if err != nil {
 			// If we can't open the file, skip git-crypting
-			log.Printf("ERR: %s", err.Error())
+			g.logger().Warn("unable to check file", "file", fn, "err", err)
 			return false
 		}
 		defer fp.Close()
 		n, err = fp.ReadAt(b, 0)
 		if err != nil {
-			log.Printf("ERR: %s", err.Error())
+			g.logger().Warn("unable to check file", "file", fn, "err", err)
 			return false
 		}
 	}
 	if n < 10 {
-		log.Printf("ERR: only read %d bytes", n)
+		g.logger().Debug("file too short to be git-crypted", "file", fn, "bytes", n)
 		return false
 	}
 	return bytes.Equal(b[0:9], gitCryptHeader)
 }
 
 // DecryptStream decrypts a stream of encrypted git-crypt format data
-// given a key file and header
+// given a key file and header. The file names no key version, so every
+// entry of the key is tried, latest first, until one verifies; only that
+// one writes to out. A header which is not a complete git-crypt header
+// returns ErrNotEncrypted.
 func (g *GitCrypt) DecryptStream(keyFile Key, header []byte, in io.ReadSeeker, out io.Writer) error {
-	if g.Debug {
-		log.Printf("header: %#v", header)
+	if len(header) != gitCryptHeaderLen || !bytes.HasPrefix(header, gitCryptHeader) {
+		return ErrNotEncrypted
 	}
 	nonce := header[10:]
-	if g.Debug {
-		log.Printf("nonce: %#v", nonce)
-	}
-	keyVersion := keyFile.Version
-
-	key, err := keyFile.Get(keyVersion)
-	if err != nil {
-		return fmt.Errorf("git-crypt: error: key version %d not available - please unlock with the latest version of the key", keyVersion)
+	g.logger().Debug("decrypting stream", "key", keyDisplayName(keyFile.KeyName), "entries", len(keyFile.Entries))
+	if len(keyFile.Entries) == 0 {
+		return fmt.Errorf("git-crypt: error: no key versions available - please unlock the key: %w", ErrNoMatchingKey)
 	}
 
 	// Attempt to detect if we've read anything already; if we haven't, ignore
@@ -269,29 +164,50 @@ func (g *GitCrypt) DecryptStream(keyFile Key, header []byte, in io.ReadSeeker, o
 			return fmt.Errorf("git-crypt: unable to read header: %s", err.Error())
 		}
 	}
+	if len(keyFile.Entries) == 1 {
+		return decryptEntryStream(keyFile.Entries[0], nonce, in, out)
+	}
+
+	start, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	for i := len(keyFile.Entries) - 1; i >= 0; i-- {
+		entry := keyFile.Entries[i]
+		err = decryptEntryStream(entry, nonce, in, io.Discard)
+		if _, seekErr := in.Seek(start, io.SeekStart); seekErr != nil {
+			return seekErr
+		}
+		if err == nil {
+			g.logger().Debug("key version matches", "key", keyDisplayName(keyFile.KeyName), "version", entry.Version)
+			return decryptEntryStream(entry, nonce, in, out)
+		}
+		if !errors.Is(err, ErrTampered) {
+			return err
+		}
+	}
+	return ErrTampered
+}
 
+// decryptEntryStream decrypts the body of a git-crypted file, after its
+// header, with a single key entry.
+func decryptEntryStream(key KeyEntry, nonce []byte, in io.Reader, out io.Writer) error {
 	aes := NewAesCtrEncryptor(key.AesKey, nonce)
+	defer aes.Destroy()
 	h := NewHMac(key.HmacKey)
 	counter := 0
+	ibuf := make([]byte, 1024)
+	obuf := make([]byte, 1024)
+	defer wipe(obuf)
 	for {
-		ibuf := make([]byte, 1024)
-		obuf := make([]byte, 1024)
 		n, err := in.Read(ibuf)
 		if err != nil {
-			//log.Printf("ERR: %s", err.Error())
 			break
 		}
-		if g.Debug {
-			log.Printf("size = %d, ibuf = %x", n, ibuf)
-		}
 		err = aes.process(ibuf, obuf, uint32(n))
 		if err != nil {
 			return err
 		}
-		if g.Debug {
-			log.Printf("input : %x", string(ibuf))
-			log.Printf("output : %x", string(obuf))
-		}
 
 		// Only iterate to number of read bytes to avoid ending nulls
 		for i := 0; i < n; i++ {
@@ -304,11 +220,8 @@ func (g *GitCrypt) DecryptStream(keyFile Key, header []byte, in io.ReadSeeker, o
 
 	// HMAC checksumming
 	digest := h.Result()
-	if g.Debug {
-		log.Printf("digest = %#v, nonce = %#v, len = %d", digest, nonce, aesEncryptorNonceLen)
-	}
 	if !leaklessEquals(digest, nonce, aesEncryptorNonceLen) {
-		return fmt.Errorf("git-crypt: error: encrypted file has been tampered with")
+		return ErrTampered
 		// Although we've already written the tampered file to stdout, exiting
 		// with a non-zero status will tell git the file has not been filtered,
 		// so git will not replace it.
@@ -319,26 +232,14 @@ func (g *GitCrypt) DecryptStream(keyFile Key, header []byte, in io.ReadSeeker, o
 
 // GpgDecryptFromFile decrypts a file using a PGP/GPG key
 func (g *GitCrypt) GpgDecryptFromFile(keyring openpgp.EntityList, path string) ([]byte, error) {
-	var filedata []byte
-	var err error
-
-	if g.Vfs == nil {
-		filedata, err = os.ReadFile(path)
-	} else {
-		fp, err := g.Vfs.Open(path)
-		if err != nil {
-			return filedata, err
-		}
-		defer fp.Close()
-		filedata, _ = io.ReadAll(fp)
-	}
+	filedata, err := g.readFile(path)
 	if err != nil {
-		log.Printf("GpgDecryptFromFile(%#v, %s): ERR: %s", keyring, path, err.Error())
+		g.logger().Debug("unable to read wrapped key", "file", path, "err", err)
 		return []byte{}, err
 	}
-	out, err := gpg.Decrypt(filedata, keyring)
+	out, err := g.openPGPWrapper(keyring).Unwrap(filedata, "")
 	if err != nil {
-		log.Printf("GpgDecryptFromFile(%#v, %s): ERR: %s", keyring, path, err.Error())
+		g.logger().Debug("unable to unwrap key", "file", path, "err", err)
 	}
 	return out, err
 }
@@ -0,0 +1,75 @@
+package gitcrypt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrTampered is returned when the HMAC of a decrypted file does not
+	// match, because it was modified or because it was encrypted with
+	// another key.
+	ErrTampered = errors.New("git-crypt: error: encrypted file has been tampered with")
+	// ErrNotEncrypted is returned when a file expected to be git-crypted
+	// lacks the git-crypt header.
+	ErrNotEncrypted = errors.New("git-crypt: not a git-crypted file")
+	// ErrNoMatchingKey is returned when none of the available keys decrypt
+	// a file or a wrapped repository key.
+	ErrNoMatchingKey = errors.New("no matching key")
+	// ErrKeyNameMismatch is returned when an unwrapped key does not have the
+	// expected key name.
+	ErrKeyNameMismatch = errors.New("key does not contain expected key name")
+)
+
+// ErrMalformedKey is returned, as a pointer, when a key file cannot be
+// parsed.
+type ErrMalformedKey struct {
+	// Field is the part of the key file which is malformed
+	Field string
+	// Offset is the byte offset of Field in the key file, or -1 if unknown
+	Offset int64
+}
+
+func (e *ErrMalformedKey) Error() string {
+	if e.Offset < 0 {
+		return "malformed key: " + e.Field
+	}
+	return fmt.Sprintf("malformed key: %s at offset %d", e.Field, e.Offset)
+}
+
+// ErrIncompatibleVersion is returned, as a pointer, when a key file has an
+// unsupported format version.
+type ErrIncompatibleVersion struct {
+	Got uint32
+}
+
+func (e *ErrIncompatibleVersion) Error() string {
+	return fmt.Sprintf("incompatible key format version %d, expected %d", e.Got, formatVersion)
+}
+
+// offsetReader counts the bytes read through it, so parse errors can say
+// where they happened.
+type offsetReader struct {
+	r      io.Reader
+	offset int64
+}
+
+// newOffsetReader wraps in, unless it is an offsetReader already.
+func newOffsetReader(in io.Reader) *offsetReader {
+	if o, ok := in.(*offsetReader); ok {
+		return o
+	}
+	return &offsetReader{r: in}
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+// malformed returns an ErrMalformedKey for a field starting at offset.
+func malformed(field string, offset int64) error {
+	return &ErrMalformedKey{Field: field, Offset: offset}
+}
@@ -0,0 +1,141 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func Test_KeyLoadErrors(t *testing.T) {
+	var buf bytes.Buffer
+	err := testRepoKey(t, "infra").Store(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := buf.Bytes()
+
+	var key Key
+	if err = key.Load(bytes.NewReader(stored)); err != nil {
+		t.Fatal(err)
+	}
+	if len(key.Entries) != 1 || key.KeyName != "infra" {
+		t.Errorf("unexpected key %#v", key)
+	}
+
+	bad := append([]byte{}, stored...)
+	bad[3] = 'X'
+	var m *ErrMalformedKey
+	err = key.Load(bytes.NewReader(bad))
+	if !errors.As(err, &m) || m.Field != "preamble" || m.Offset != 0 {
+		t.Errorf("expected malformed preamble, got %v", err)
+	}
+
+	bad = append([]byte{}, stored...)
+	bad[15] = 3
+	var v *ErrIncompatibleVersion
+	err = key.Load(bytes.NewReader(bad))
+	if !errors.As(err, &v) || v.Got != 3 {
+		t.Errorf("expected incompatible version, got %v", err)
+	}
+
+	// Truncated in the AES key of the first entry, which starts after the
+	// preamble (16), the key name field (8 + 5), the header end (4) and the
+	// version field (12)
+	err = key.Load(bytes.NewReader(stored[:60]))
+	if !errors.As(err, &m) || m.Field != "AES key" || m.Offset != 45 {
+		t.Errorf("expected malformed AES key at offset 45, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "malformed") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+func Test_DecryptErrors(t *testing.T) {
+	key := testRepoKey(t, "")
+	other := testRepoKey(t, "")
+	g := GitCrypt{}
+
+	encrypted := testEncrypt(t, key, "secret")
+	decrypt := func(k Key, data []byte) error {
+		var out bytes.Buffer
+		return g.DecryptStream(k, data[:gitCryptHeaderLen], bytes.NewReader(data), &out)
+	}
+	if err := decrypt(key, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt(other, encrypted); !errors.Is(err, ErrTampered) {
+		t.Errorf("expected ErrTampered, got %v", err)
+	}
+	if err := decrypt(Key{}, encrypted); !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("expected ErrNoMatchingKey, got %v", err)
+	}
+	for _, header := range [][]byte{encrypted[:9], []byte("plain text, not encrypted")[:gitCryptHeaderLen]} {
+		var out bytes.Buffer
+		if err := g.DecryptStream(key, header, bytes.NewReader(encrypted), &out); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("expected ErrNotEncrypted for header %q, got %v", header, err)
+		}
+	}
+	if _, err := decryptBlob(key.Entries[0], []byte("plain text, not encrypted")); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected ErrNotEncrypted, got %v", err)
+	}
+	if _, _, err := g.Merge([]Key{other}, nil, encrypted, encrypted, MergeOptions{}); !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("expected ErrNoMatchingKey, got %v", err)
+	}
+}
+
+func Test_DecryptRepoKeysErrors(t *testing.T) {
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+	alice := testEntity(t, "alice")
+	bob := testEntity(t, "bob")
+
+	w := NewOpenPGPWrapper(openpgp.EntityList{alice})
+	for _, name := range []string{"", "infra"} {
+		_, err := g.WrapRepoKey(w, testRepoKey(t, name), "", keysPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bob tries Alice's wrapped keys, and each attempt is reported
+	_, err := g.DecryptRepoKeys(openpgp.EntityList{bob}, 0, w.Recipients(), keysPath)
+	if !errors.Is(err, ErrNoMatchingKey) {
+		t.Fatalf("expected ErrNoMatchingKey, got %v", err)
+	}
+	for _, want := range []string{"key default:", "key infra:", filepath.Join("default", "0", w.Recipients()[0]+".gpg")} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q: %s", want, err.Error())
+		}
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 3 {
+		t.Errorf("expected a joined error of 3, got %v", err)
+	}
+
+	// A key stored under the wrong name
+	var plain bytes.Buffer
+	err = testRepoKey(t, "other").Store(&plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, wrapped, err := w.Wrap(plain.Bytes(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(keysPath, "misnamed", "0")
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, id+w.Extension()), wrapped, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.UnwrapRepoKey(w, "misnamed", 0, nil, keysPath)
+	if !errors.Is(err, ErrKeyNameMismatch) {
+		t.Errorf("expected ErrKeyNameMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// gitCommand runs git in a repository, returning its standard output.
+// Standard error is included in the returned error.
+func gitCommand(repoPath string, stdin io.Reader, args ...string) ([]byte, error) {
+	return gitCommandEnv(repoPath, nil, stdin, args...)
+}
+
+// gitCommandEnv is gitCommand with extra environment variables.
+func gitCommandEnv(repoPath string, env []string, stdin io.Reader, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", repoPath}, args...)...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return stdout.Bytes(), fmt.Errorf("git %s: %s: %s", args[0], err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitConfigGet returns a git configuration value for a repository, or an
+// empty string if it is not set.
+func gitConfigGet(repoPath string, name string) (string, error) {
+	out, err := exec.Command("git", "-C", repoPath, "config", "--get", name).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// Not set
+			return "", nil
+		}
+		if _, statErr := os.Stat(repoPath); statErr != nil {
+			return "", statErr
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitConfigGetAll returns every value of a multi-valued git configuration
+// key for a repository.
+func gitConfigGetAll(repoPath string, name string) ([]string, error) {
+	out, err := exec.Command("git", "-C", repoPath, "config", "--get-all", name).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// Not set
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(out), "\n"), "\n"), nil
+}
+
+// gitFile is a file tracked by git.
+type gitFile struct {
+	Path string
+	// Mode is the git file mode. It is empty for files listed from the
+	// working tree.
+	Mode string
+	// Blob is the object id of the file contents. It is empty for files
+	// listed from the working tree.
+	Blob string
+}
+
+// gitListFiles lists the files in a commit, or in the index if commit is
+// empty. Symbolic links and submodules are skipped.
+func gitListFiles(repoPath string, commit string) ([]gitFile, error) {
+	var out []byte
+	var err error
+	if commit == "" {
+		out, err = gitCommand(repoPath, nil, "ls-files", "-s", "-z")
+	} else {
+		out, err = gitCommand(repoPath, nil, "ls-tree", "-r", "-z", commit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make([]gitFile, 0)
+	for _, record := range strings.Split(string(out), "\x00") {
+		meta, path, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		// ls-files: <mode> <blob> <stage>, ls-tree: <mode> <type> <blob>
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || (fields[0] != "100644" && fields[0] != "100755") {
+			continue
+		}
+		blob := fields[1]
+		if commit != "" {
+			blob = fields[2]
+		}
+		files = append(files, gitFile{Path: path, Mode: fields[0], Blob: blob})
+	}
+	return files, nil
+}
+
+// gitListWorkTree lists the files tracked in the working tree.
+func gitListWorkTree(repoPath string) ([]gitFile, error) {
+	out, err := gitCommand(repoPath, nil, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]gitFile, 0)
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path != "" {
+			files = append(files, gitFile{Path: path})
+		}
+	}
+	return files, nil
+}
+
+// gitFilterAttributes returns the filter attribute of each path, read from
+// the index if cached is set and from the working tree otherwise. Paths
+// without a filter are omitted. env may point GIT_INDEX_FILE at another
+// index.
+func gitFilterAttributes(repoPath string, env []string, paths []string, cached bool) (map[string]string, error) {
+	attrs := make(map[string]string)
+	if len(paths) == 0 {
+		return attrs, nil
+	}
+	args := []string{"check-attr", "-z", "--stdin"}
+	if cached {
+		args = append(args, "--cached")
+	}
+	args = append(args, "filter")
+	out, err := gitCommandEnv(repoPath, env, strings.NewReader(strings.Join(paths, "\x00")+"\x00"), args...)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		if v := fields[i+2]; v != "unspecified" && v != "unset" {
+			attrs[fields[i]] = v
+		}
+	}
+	return attrs, nil
+}
+
+// gitBlobReader reads objects through a long running "git cat-file
+// --batch".
+type gitBlobReader struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func newGitBlobReader(repoPath string) (*gitBlobReader, error) {
+	cmd := exec.Command("git", "-C", repoPath, "cat-file", "--batch")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &gitBlobReader{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// Read returns the contents of an object.
+func (b *gitBlobReader) Read(id string) ([]byte, error) {
+	_, err := io.WriteString(b.in, id+"\n")
+	if err != nil {
+		return nil, err
+	}
+	header, err := b.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size+1)
+	_, err = io.ReadFull(b.out, data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:size], nil
+}
+
+// Close stops the cat-file process.
+func (b *gitBlobReader) Close() error {
+	b.in.Close()
+	return b.cmd.Wait()
+}
@@ -1,13 +1,40 @@
 package gitcrypt
 
-import "golang.org/x/tools/godoc/vfs"
+import (
+	"log/slog"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+	"golang.org/x/tools/godoc/vfs"
+)
 
 // GitCrypt is the namespace
 type GitCrypt struct {
 	// Debug represents whether debug output will be enabled. Do not turn
 	// this on until you really mean it.
 	Debug bool
+	// Logger optionally receives structured diagnostics. If it is nil, a
+	// logger writing to standard error is used when Debug is set, and
+	// nothing is logged otherwise. Key material and plain text are never
+	// logged.
+	Logger *slog.Logger
 	// Vfs represents an optional virtual filesystem. If it is nil, the
 	// standard OS file opening functions will be used.
 	Vfs vfs.FileSystem
+	// Prompt is an optional callback used to obtain passphrases for locked
+	// GPG private keys. If it is nil, locked keys cannot be used.
+	Prompt gpg.PromptFunc
+	// Agent is an optional connection to gpg-agent. If it is set, repository
+	// keys are unwrapped by the agent, and the keyring passed to the
+	// decryption functions only needs to hold public keys.
+	Agent *gpg.Agent
+	// KeyHelper is an optional external program used by KeyFromHelper to
+	// obtain unlocked repository keys. If it is empty, the
+	// gitcrypt.keyhelper git configuration value of the repository is used.
+	KeyHelper string
+	// Trusted optionally enables strict verification of wrapped GPG key
+	// files, which must then be signed by one of these keys. This stops
+	// anyone with push access from planting a repository key of their
+	// choosing.
+	Trusted openpgp.EntityList
 }
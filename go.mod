@@ -7,6 +7,7 @@ toolchain go1.24.3
 replace github.com/jbuchbinder/go-git-crypt/gpg => ./gpg
 
 require (
+	filippo.io/age v1.2.1
 	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/jbuchbinder/go-git-crypt/gpg v0.0.0-20250212141212-325ebd1e616b
 	golang.org/x/crypto v0.41.0
@@ -14,7 +15,9 @@ require (
 )
 
 require (
+	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/cloudflare/circl v1.6.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/term v0.34.0 // indirect
 )
@@ -0,0 +1,495 @@
+package gpg
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+const (
+	// agentNoSecretKey is the gpg-error code returned by HAVEKEY when the
+	// agent does not hold the requested key.
+	agentNoSecretKey = 67108881
+
+	packetTagPKESK = 1
+	packetTagSKESK = 3
+)
+
+var (
+	// ErrAgentNoKey is returned when gpg-agent holds none of the keys a
+	// message has been encrypted to.
+	ErrAgentNoKey = errors.New("gpg: gpg-agent has no secret key for this message")
+	// ErrUnsupportedAgentKey is returned for recipient key algorithms which
+	// cannot be unwrapped through gpg-agent by this package.
+	ErrUnsupportedAgentKey = errors.New("gpg: key algorithm not supported for gpg-agent decryption")
+)
+
+// Agent is a connection to a running gpg-agent, used to decrypt messages
+// without the secret key material ever entering this process.
+type Agent struct {
+	mu   sync.Mutex
+	c    net.Conn
+	conn *assuanConn
+}
+
+// AgentSocketPath returns the location of the gpg-agent socket, asking
+// gpgconf first and falling back to $GNUPGHOME or ~/.gnupg.
+func AgentSocketPath() (string, error) {
+	out, err := exec.Command("gpgconf", "--list-dirs", "agent-socket").Output()
+	if err == nil && len(bytes.TrimSpace(out)) > 0 {
+		return string(bytes.TrimSpace(out)), nil
+	}
+	home := os.Getenv("GNUPGHOME")
+	if home == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		home = filepath.Join(userHome, ".gnupg")
+	}
+	return filepath.Join(home, "S.gpg-agent"), nil
+}
+
+// DialAgent connects to the gpg-agent listening on socketPath. If
+// socketPath is empty, AgentSocketPath is used to locate it.
+func DialAgent(socketPath string) (*Agent, error) {
+	if socketPath == "" {
+		var err error
+		socketPath, err = AgentSocketPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+	c, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newAssuanConn(c, c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &Agent{c: c, conn: conn}, nil
+}
+
+// Close terminates the connection to gpg-agent.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, _ = a.conn.transact("BYE", nil)
+	return a.c.Close()
+}
+
+// HaveKey reports whether gpg-agent holds the secret key with the given
+// keygrip.
+func (a *Agent) HaveKey(keygrip string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err := a.conn.transact("HAVEKEY "+keygrip, nil)
+	if err != nil {
+		var ae *AssuanError
+		if errors.As(err, &ae) && ae.Code == agentNoSecretKey {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Decrypt decrypts an input byte array, asking gpg-agent to unwrap the
+// session key. publicKeyring only needs to contain the public keys of the
+// recipients, which are used to find the keygrips to ask the agent for.
+func (a *Agent) Decrypt(in []byte, publicKeyring openpgp.EntityList) ([]byte, error) {
+	logger().Debug("gpg.Agent.Decrypt", "bytes", len(in))
+	md, err := a.decrypt(in, publicKeyring, publicKeyring)
+	if err != nil {
+		return []byte{}, err
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+// DecryptVerified decrypts an input byte array like Decrypt, and
+// additionally requires it to carry a valid signature by one of the keys
+// in trusted, which is returned.
+func (a *Agent) DecryptVerified(in []byte, publicKeyring openpgp.EntityList, trusted openpgp.EntityList) ([]byte, *openpgp.Entity, error) {
+	logger().Debug("gpg.Agent.DecryptVerified", "bytes", len(in))
+	md, err := a.decrypt(in, publicKeyring, append(append(openpgp.EntityList{}, publicKeyring...), trusted...))
+	if err != nil {
+		return []byte{}, nil, err
+	}
+	return verifyMessage(md, trusted)
+}
+
+// decrypt has the agent unwrap the session key of a message, returning the
+// decrypted message read with keyring, which is used to find signers.
+func (a *Agent) decrypt(in []byte, publicKeyring openpgp.EntityList, keyring openpgp.EntityList) (*openpgp.MessageDetails, error) {
+	if strings.Contains(string(in), "BEGIN PGP MESSAGE") {
+		block, err := armor.Decode(bytes.NewReader(in))
+		if err != nil {
+			return nil, err
+		}
+		in, err = io.ReadAll(block.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	esks, rest, err := splitSessionKeyPackets(in)
+	if err != nil {
+		return nil, err
+	}
+	p, err := packet.Read(bytes.NewReader(rest))
+	if err != nil {
+		return nil, err
+	}
+	edp, ok := p.(packet.EncryptedDataPacket)
+	if !ok {
+		return nil, errors.New("gpg.Agent.Decrypt(): message is not encrypted")
+	}
+
+	for _, esk := range esks {
+		for _, k := range publicKeyring.KeysById(esk.keyID) {
+			grip, err := Keygrip(k.PublicKey)
+			if err != nil {
+				logger().Debug("gpg.Agent.Decrypt: no keygrip", "key", k.PublicKey.KeyIdString(), "err", err)
+				continue
+			}
+			have, err := a.HaveKey(grip)
+			if err != nil {
+				return nil, err
+			}
+			if !have {
+				continue
+			}
+			cipherFunc, sessionKey, err := a.pkDecrypt(grip, esk)
+			if err != nil {
+				return nil, err
+			}
+			decrypted, err := edp.Decrypt(cipherFunc, sessionKey)
+			if err != nil {
+				return nil, err
+			}
+			// Read the entire plaintext so the integrity check runs before
+			// any of it is used
+			inner, err := io.ReadAll(decrypted)
+			if err != nil {
+				return nil, err
+			}
+			return openpgp.ReadMessage(bytes.NewReader(inner), keyring, nil, nil)
+		}
+	}
+	return nil, ErrAgentNoKey
+}
+
+// pkDecrypt asks the agent to decrypt the session key held in a PKESK
+// packet with the key identified by keygrip.
+func (a *Agent) pkDecrypt(keygrip string, esk encryptedSessionKey) (packet.CipherFunction, []byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err := a.conn.transact("SETKEY "+keygrip, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	padded := true
+	a.conn.onStatus = func(line string) {
+		if line == "PADDING 0" {
+			padded = false
+		}
+	}
+	defer func() { a.conn.onStatus = nil }()
+
+	data, err := a.conn.transact("PKDECRYPT", func(keyword string) ([]byte, error) {
+		if keyword != "CIPHERTEXT" {
+			return nil, fmt.Errorf("gpg.Agent: unexpected inquiry %s", keyword)
+		}
+		return esk.sexp(), nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	value, err := parseAgentValue(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if padded {
+		value, err = stripPKCS1(value)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return decodeSessionKey(value)
+}
+
+// encryptedSessionKey holds the fields of a version 3 public key encrypted
+// session key packet which gpg-agent needs to see.
+type encryptedSessionKey struct {
+	keyID uint64
+	algo  packet.PublicKeyAlgorithm
+	mpi   []byte
+}
+
+// sexp renders the encrypted session key as the canonical S-expression
+// expected by PKDECRYPT.
+func (e encryptedSessionKey) sexp() []byte {
+	a := e.mpi
+	if len(a) > 0 && a[0]&0x80 != 0 {
+		a = append([]byte{0}, a...)
+	}
+	var b bytes.Buffer
+	b.WriteString("(7:enc-val(5:flags5:pkcs1)(3:rsa(1:a")
+	b.WriteString(strconv.Itoa(len(a)) + ":")
+	b.Write(a)
+	b.WriteString(")))")
+	return b.Bytes()
+}
+
+// splitSessionKeyPackets parses the leading session key packets of a
+// binary OpenPGP message, returning the public key encrypted ones and the
+// remainder of the message.
+func splitSessionKeyPackets(in []byte) ([]encryptedSessionKey, []byte, error) {
+	esks := make([]encryptedSessionKey, 0)
+	for len(in) > 0 {
+		tag, body, next, err := readPacketHeader(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tag != packetTagPKESK && tag != packetTagSKESK {
+			return esks, in, nil
+		}
+		if tag == packetTagPKESK {
+			esk, err := parsePKESK(body)
+			if err == nil {
+				esks = append(esks, esk)
+			}
+		}
+		in = next
+	}
+	return nil, nil, errors.New("gpg.Agent: no encrypted data in message")
+}
+
+func parsePKESK(body []byte) (encryptedSessionKey, error) {
+	if len(body) < 12 || body[0] != 3 {
+		return encryptedSessionKey{}, errors.New("gpg.Agent: unsupported PKESK version")
+	}
+	esk := encryptedSessionKey{
+		keyID: binary.BigEndian.Uint64(body[1:9]),
+		algo:  packet.PublicKeyAlgorithm(body[9]),
+	}
+	if esk.algo != packet.PubKeyAlgoRSA && esk.algo != packet.PubKeyAlgoRSAEncryptOnly {
+		return encryptedSessionKey{}, ErrUnsupportedAgentKey
+	}
+	bits := int(binary.BigEndian.Uint16(body[10:12]))
+	n := (bits + 7) / 8
+	if len(body) < 12+n {
+		return encryptedSessionKey{}, errors.New("gpg.Agent: truncated PKESK")
+	}
+	esk.mpi = body[12 : 12+n]
+	return esk, nil
+}
+
+// readPacketHeader decodes the header of the OpenPGP packet at the start
+// of in, returning the packet tag, its body and the data following it.
+// Partial body lengths are not supported, since they never appear on
+// session key packets.
+func readPacketHeader(in []byte) (tag int, body []byte, next []byte, err error) {
+	malformed := errors.New("gpg.Agent: malformed packet header")
+	if len(in) < 2 || in[0]&0x80 == 0 {
+		return 0, nil, nil, malformed
+	}
+	var length, hdr int
+	if in[0]&0x40 != 0 {
+		// New format
+		tag = int(in[0] & 0x3f)
+		switch o := in[1]; {
+		case o < 192:
+			length, hdr = int(o), 2
+		case o < 224:
+			if len(in) < 3 {
+				return 0, nil, nil, malformed
+			}
+			length, hdr = (int(o)-192)<<8+int(in[2])+192, 3
+		case o == 255:
+			if len(in) < 6 {
+				return 0, nil, nil, malformed
+			}
+			length, hdr = int(binary.BigEndian.Uint32(in[2:6])), 6
+		default:
+			// Partial body length; only valid for data packets, whose
+			// bodies are never parsed
+			if !isDataPacketTag(tag) {
+				return 0, nil, nil, malformed
+			}
+			return tag, nil, in, nil
+		}
+	} else {
+		// Old format
+		tag = int(in[0]>>2) & 0xf
+		switch in[0] & 3 {
+		case 0:
+			length, hdr = int(in[1]), 2
+		case 1:
+			if len(in) < 3 {
+				return 0, nil, nil, malformed
+			}
+			length, hdr = int(binary.BigEndian.Uint16(in[1:3])), 3
+		case 2:
+			if len(in) < 5 {
+				return 0, nil, nil, malformed
+			}
+			length, hdr = int(binary.BigEndian.Uint32(in[1:5])), 5
+		default:
+			// Indeterminate length; runs to the end of the message
+			return tag, in[1:], nil, nil
+		}
+	}
+	if len(in) < hdr+length {
+		return 0, nil, nil, malformed
+	}
+	return tag, in[hdr : hdr+length], in[hdr+length:], nil
+}
+
+// isDataPacketTag reports whether packets with a tag may use partial body
+// lengths: compressed, encrypted and literal data packets.
+func isDataPacketTag(tag int) bool {
+	switch tag {
+	case 8, 9, 11, 18, 20:
+		return true
+	}
+	return false
+}
+
+// parseAgentValue extracts the plaintext from a "(5:value...)" response.
+func parseAgentValue(data []byte) ([]byte, error) {
+	exp, err := parseSexp(data)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := exp.([]interface{})
+	if !ok || len(list) != 2 {
+		return nil, errors.New("gpg.Agent: unexpected PKDECRYPT response")
+	}
+	name, _ := list[0].([]byte)
+	value, ok := list[1].([]byte)
+	if string(name) != "value" || !ok {
+		return nil, errors.New("gpg.Agent: unexpected PKDECRYPT response")
+	}
+	return value, nil
+}
+
+// parseSexp decodes a canonical S-expression into nested []interface{}
+// lists of []byte atoms.
+func parseSexp(data []byte) (interface{}, error) {
+	exp, rest, err := parseSexpElement(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 && !(len(rest) == 1 && rest[0] == 0) {
+		return nil, errors.New("sexp: trailing data")
+	}
+	return exp, nil
+}
+
+func parseSexpElement(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	if data[0] == '(' {
+		list := make([]interface{}, 0)
+		data = data[1:]
+		for {
+			if len(data) == 0 {
+				return nil, nil, io.ErrUnexpectedEOF
+			}
+			if data[0] == ')' {
+				return list, data[1:], nil
+			}
+			var elem interface{}
+			var err error
+			elem, data, err = parseSexpElement(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, elem)
+		}
+	}
+	colon := bytes.IndexByte(data, ':')
+	if colon < 1 {
+		return nil, nil, errors.New("sexp: malformed atom")
+	}
+	n, err := strconv.Atoi(string(data[:colon]))
+	if err != nil || n < 0 || len(data) < colon+1+n {
+		return nil, nil, errors.New("sexp: malformed atom length")
+	}
+	return data[colon+1 : colon+1+n], data[colon+1+n:], nil
+}
+
+// stripPKCS1 removes EME-PKCS1-v1_5 padding from a decrypted value whose
+// leading zero byte may already have been dropped.
+func stripPKCS1(value []byte) ([]byte, error) {
+	if len(value) > 0 && value[0] == 0 {
+		value = value[1:]
+	}
+	if len(value) < 10 || value[0] != 2 {
+		return nil, errors.New("gpg.Agent: bad PKCS#1 padding")
+	}
+	sep := bytes.IndexByte(value[1:], 0)
+	if sep < 8 {
+		return nil, errors.New("gpg.Agent: bad PKCS#1 padding")
+	}
+	return value[sep+2:], nil
+}
+
+// decodeSessionKey splits a decrypted v3 session key into its cipher
+// algorithm and key, verifying the checksum.
+func decodeSessionKey(value []byte) (packet.CipherFunction, []byte, error) {
+	if len(value) < 4 {
+		return 0, nil, errors.New("gpg.Agent: session key too short")
+	}
+	cipherFunc := packet.CipherFunction(value[0])
+	key := value[1 : len(value)-2]
+	var sum uint16
+	for _, b := range key {
+		sum += uint16(b)
+	}
+	if sum != binary.BigEndian.Uint16(value[len(value)-2:]) {
+		return 0, nil, errors.New("gpg.Agent: session key checksum mismatch")
+	}
+	if cipherFunc.KeySize() != len(key) {
+		return 0, nil, errors.New("gpg.Agent: session key has wrong size for cipher")
+	}
+	return cipherFunc, key, nil
+}
+
+// Keygrip computes the libgcrypt keygrip of a public key, which gpg-agent
+// uses to identify secret keys. Only RSA keys are currently supported.
+func Keygrip(pk *packet.PublicKey) (string, error) {
+	switch pub := pk.PublicKey.(type) {
+	case *rsa.PublicKey:
+		n := pub.N.Bytes()
+		if len(n) > 0 && n[0]&0x80 != 0 {
+			n = append([]byte{0}, n...)
+		}
+		sum := sha1.Sum(n)
+		return strings.ToUpper(hex.EncodeToString(sum[:])), nil
+	default:
+		return "", ErrUnsupportedAgentKey
+	}
+}
@@ -0,0 +1,215 @@
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bmizerany/assert"
+)
+
+// fakeAgent is a minimal gpg-agent stand-in holding RSA secret keys indexed
+// by keygrip.
+type fakeAgent struct {
+	keys map[string]*rsa.PrivateKey
+	l    net.Listener
+}
+
+func newFakeAgent(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	dir, err := os.MkdirTemp("", "gpgagent")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socketPath := filepath.Join(dir, "S.gpg-agent")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { l.Close() })
+	a := &fakeAgent{keys: keys, l: l}
+	go a.serve()
+	return socketPath
+}
+
+func (a *fakeAgent) serve() {
+	for {
+		c, err := a.l.Accept()
+		if err != nil {
+			return
+		}
+		go a.handle(c)
+	}
+}
+
+func (a *fakeAgent) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	io.WriteString(c, "OK Pleased to meet you\n")
+	var current string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch cmd {
+		case "HAVEKEY":
+			if _, ok := a.keys[arg]; ok {
+				io.WriteString(c, "OK\n")
+			} else {
+				fmt.Fprintf(c, "ERR %d No secret key\n", agentNoSecretKey)
+			}
+		case "SETKEY":
+			current = arg
+			io.WriteString(c, "OK\n")
+		case "PKDECRYPT":
+			io.WriteString(c, "INQUIRE CIPHERTEXT\n")
+			var ciphertext []byte
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\n")
+				if line == "END" {
+					break
+				}
+				ciphertext = append(ciphertext, assuanUnescape(strings.TrimPrefix(line, "D "))...)
+			}
+			exp, err := parseSexp(ciphertext)
+			if err != nil {
+				fmt.Fprintf(c, "ERR 1 %s\n", err.Error())
+				continue
+			}
+			// (enc-val (flags pkcs1) (rsa (a MPI)))
+			mpi := exp.([]interface{})[2].([]interface{})[1].([]interface{})[1].([]byte)
+			// Like gpg-agent, accept the sign-preserving leading zero
+			mpi = bytes.TrimLeft(mpi, "\x00")
+			plain, err := rsa.DecryptPKCS1v15(rand.Reader, a.keys[current], mpi)
+			if err != nil {
+				fmt.Fprintf(c, "ERR 2 %s\n", err.Error())
+				continue
+			}
+			value := fmt.Sprintf("(5:value%d:%s)", len(plain), plain)
+			io.WriteString(c, "S PADDING 0\nD "+assuanEscape([]byte(value))+"\nOK\n")
+		case "BYE":
+			io.WriteString(c, "OK closing connection\n")
+			return
+		default:
+			io.WriteString(c, "ERR 3 Unknown command\n")
+		}
+	}
+}
+
+func TestAgentDecrypt(t *testing.T) {
+	priv, err := ArmoredKeyIngest([]byte(PRIVKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pub, err := ArmoredKeyIngest([]byte(PUBKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	keys := map[string]*rsa.PrivateKey{}
+	for _, sk := range priv.Subkeys {
+		grip, err := Keygrip(sk.PublicKey)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		keys[grip] = sk.PrivateKey.PrivateKey.(*rsa.PrivateKey)
+	}
+	socketPath := newFakeAgent(t, keys)
+
+	enc, err := Encrypt([]byte(DECODEDPAYLOAD), openpgp.EntityList{pub}, "", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	agent, err := DialAgent(socketPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer agent.Close()
+
+	// Only the public key is given to the decryption; the secret key lives
+	// in the agent
+	out, err := agent.Decrypt(enc, openpgp.EntityList{pub})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+
+	signer, err := openpgp.NewEntity("Signer", "", "signer@example.com", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	signed, err := EncryptSigned([]byte(DECODEDPAYLOAD), openpgp.EntityList{pub}, signer, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	out, by, err := agent.DecryptVerified(signed, openpgp.EntityList{pub}, openpgp.EntityList{signer})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+	assert.Equal(t, Fingerprint(by), Fingerprint(signer))
+}
+
+func TestAgentDecryptNoKey(t *testing.T) {
+	pub, err := ArmoredKeyIngest([]byte(PUBKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	socketPath := newFakeAgent(t, map[string]*rsa.PrivateKey{})
+
+	enc, err := Encrypt([]byte(DECODEDPAYLOAD), openpgp.EntityList{pub}, "", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	agent, err := DialAgent(socketPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer agent.Close()
+
+	_, err = agent.Decrypt(enc, openpgp.EntityList{pub})
+	assert.Equal(t, err, ErrAgentNoKey)
+}
+
+func TestSplitSessionKeyPacketsPartialLength(t *testing.T) {
+	// Partial body lengths on session key packets used to loop forever
+	for _, in := range [][]byte{
+		{0xc1, 0xe0, 0x03, 0x00},
+		{0xc3, 0xe0, 0x04, 0x07},
+	} {
+		done := make(chan error, 1)
+		go func() {
+			_, _, err := splitSessionKeyPackets(in)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.NotEqual(t, err, nil)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("splitSessionKeyPackets(%x) did not return", in)
+		}
+	}
+
+	// Data packets may still use them
+	esks, rest, err := splitSessionKeyPackets([]byte{0xd2, 0xe0, 0x01})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(esks), 0)
+	assert.Equal(t, len(rest), 3)
+}
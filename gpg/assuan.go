@@ -0,0 +1,178 @@
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// assuanMaxLine is the maximum length of a single Assuan protocol line,
+// including the terminating linefeed.
+const assuanMaxLine = 1000
+
+// AssuanError is returned when the remote end of an Assuan connection
+// replies to a command with ERR.
+type AssuanError struct {
+	Code        uint32
+	Description string
+}
+
+func (e *AssuanError) Error() string {
+	return fmt.Sprintf("assuan: ERR %d %s", e.Code, e.Description)
+}
+
+// assuanConn is a minimal client side implementation of the Assuan IPC
+// protocol used by gpg-agent and pinentry.
+type assuanConn struct {
+	r *bufio.Reader
+	w io.Writer
+
+	// onStatus, if set, receives the text of every status (S) line.
+	onStatus func(line string)
+}
+
+// newAssuanConn wraps a reader and writer pair and consumes the initial
+// greeting sent by the server.
+func newAssuanConn(r io.Reader, w io.Writer) (*assuanConn, error) {
+	c := &assuanConn{r: bufio.NewReader(r), w: w}
+	_, err := c.readResponse(nil)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// transact sends a single command and collects all data lines returned
+// before the terminating OK. inquire is called for every INQUIRE the
+// server issues, and may be nil if the command never inquires.
+func (c *assuanConn) transact(cmd string, inquire func(keyword string) ([]byte, error)) ([]byte, error) {
+	if len(cmd)+1 > assuanMaxLine {
+		return nil, errors.New("assuan: command line too long")
+	}
+	_, err := io.WriteString(c.w, cmd+"\n")
+	if err != nil {
+		return nil, err
+	}
+	return c.readResponse(inquire)
+}
+
+func (c *assuanConn) readResponse(inquire func(keyword string) ([]byte, error)) ([]byte, error) {
+	var data bytes.Buffer
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.Bytes(), nil
+		case strings.HasPrefix(line, "ERR "):
+			return nil, parseAssuanError(line[4:])
+		case strings.HasPrefix(line, "D "):
+			data.Write(assuanUnescape(line[2:]))
+		case strings.HasPrefix(line, "INQUIRE "):
+			fields := strings.Fields(line[8:])
+			if len(fields) == 0 {
+				return nil, errors.New("assuan: INQUIRE without keyword")
+			}
+			keyword := fields[0]
+			if inquire == nil {
+				_, err = io.WriteString(c.w, "CAN\n")
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			reply, err := inquire(keyword)
+			if err != nil {
+				_, _ = io.WriteString(c.w, "CAN\n")
+				return nil, err
+			}
+			err = c.sendData(reply)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.WriteString(c.w, "END\n")
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "S "):
+			if c.onStatus != nil {
+				c.onStatus(line[2:])
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+			// Comment lines are informational only
+		default:
+			return nil, fmt.Errorf("assuan: unexpected line %q", line)
+		}
+	}
+}
+
+// sendData writes raw data as a sequence of escaped D lines.
+func (c *assuanConn) sendData(data []byte) error {
+	escaped := assuanEscape(data)
+	// Leave room for the "D " prefix and the trailing linefeed, and avoid
+	// splitting an escape sequence across lines.
+	chunk := assuanMaxLine - 4
+	for len(escaped) > 0 {
+		n := chunk
+		if n > len(escaped) {
+			n = len(escaped)
+		}
+		for n > 2 && (escaped[n-1] == '%' || escaped[n-2] == '%') {
+			n--
+		}
+		_, err := io.WriteString(c.w, "D "+escaped[:n]+"\n")
+		if err != nil {
+			return err
+		}
+		escaped = escaped[n:]
+	}
+	return nil
+}
+
+func parseAssuanError(s string) error {
+	e := &AssuanError{}
+	code, desc, _ := strings.Cut(s, " ")
+	_, err := fmt.Sscanf(code, "%d", &e.Code)
+	if err != nil {
+		return fmt.Errorf("assuan: malformed error %q", s)
+	}
+	e.Description = desc
+	return e
+}
+
+// assuanEscape percent-escapes the characters which may not appear
+// literally on an Assuan line.
+func assuanEscape(in []byte) string {
+	var b strings.Builder
+	for _, c := range in {
+		if c == '%' || c == '\r' || c == '\n' || c < 0x20 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func assuanUnescape(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err == nil {
+				out = append(out, byte(c))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return out
+}
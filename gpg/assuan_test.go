@@ -0,0 +1,19 @@
+package gpg
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestAssuanInquireWithoutKeyword(t *testing.T) {
+	c, err := newAssuanConn(strings.NewReader("OK ready\nINQUIRE \n"), io.Discard)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = c.transact("PKDECRYPT", func(string) ([]byte, error) { return nil, nil })
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, strings.Contains(err.Error(), "INQUIRE"), true)
+}
@@ -7,6 +7,7 @@ toolchain go1.24.3
 require (
 	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869
+	golang.org/x/term v0.34.0
 )
 
 require (
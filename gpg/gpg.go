@@ -5,7 +5,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"io"
-	"log"
 	"os"
 	"strings"
 
@@ -16,24 +15,31 @@ import (
 
 // Decrypt decrypts an input byte array with keys in secretkeyring
 func Decrypt(in []byte, secretKeyring openpgp.EntityList) ([]byte, error) {
-	log.Printf("gpg.Decrypt(%d bytes)", len(in))
+	return DecryptWithPrompt(in, secretKeyring, nil)
+}
+
+// DecryptWithPrompt decrypts an input byte array with keys in
+// secretKeyring, calling prompt to obtain the passphrase for any locked
+// private keys. A nil prompt behaves like Decrypt.
+func DecryptWithPrompt(in []byte, secretKeyring openpgp.EntityList, prompt PromptFunc) ([]byte, error) {
+	logger().Debug("gpg.Decrypt", "bytes", len(in))
 
 	// Determine if there's any armoring going on
 	if strings.Contains(string(in), "BEGIN PGP MESSAGE") {
-		log.Printf("gpg.Decrypt(): Found armored message data")
+		logger().Debug("gpg.Decrypt: found armored message data")
 		result, err := armor.Decode(bytes.NewReader(in))
 		if err != nil {
 			return []byte{}, err
 		}
-		md, err := openpgp.ReadMessage(result.Body, secretKeyring, nil, nil)
+		md, err := openpgp.ReadMessage(result.Body, secretKeyring, promptFunction(prompt), nil)
 		if err != nil {
 			return []byte{}, err
 		}
 		return io.ReadAll(md.UnverifiedBody)
 	}
 
-	log.Printf("gpg.Decrypt(): Processing raw data")
-	md, err := openpgp.ReadMessage(bytes.NewReader(in), secretKeyring, nil, nil)
+	logger().Debug("gpg.Decrypt: processing raw data")
+	md, err := openpgp.ReadMessage(bytes.NewReader(in), secretKeyring, promptFunction(prompt), nil)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -45,12 +51,12 @@ func Decrypt(in []byte, secretKeyring openpgp.EntityList) ([]byte, error) {
 // keys if specified in masterKeyFilePath. It returns encrypted data
 // or an error if one is encountered.
 func Encrypt(in []byte, publicKeyring openpgp.EntityList, id string, masterKeyFilePath string) ([]byte, error) {
-	log.Printf("gpg.Encrypt(%d bytes, key %s)", len(in), id)
+	logger().Debug("gpg.Encrypt", "bytes", len(in), "key", id)
 
 	myID := id
 	if myID == "" && len(publicKeyring) > 0 {
 		myID = EntityID(publicKeyring[0])
-		log.Printf("gpg.Encrypt(): Autodetected entity id %s", myID)
+		logger().Debug("gpg.Encrypt: autodetected entity id", "key", myID)
 	}
 
 	key := getKeyByID(publicKeyring, myID)
@@ -66,15 +72,15 @@ func Encrypt(in []byte, publicKeyring openpgp.EntityList, id string, masterKeyFi
 		// Attach the master key to it so that we can decrypt
 		masterkeyfile, err := os.ReadFile(masterKeyFilePath)
 		if err != nil {
-			log.Printf("gpg.Encrypt(): Unable to ingest master GPG key: %s", err.Error())
+			logger().Warn("gpg.Encrypt: unable to ingest master GPG key", "err", err)
 			el = openpgp.EntityList{key}
 		} else {
 			masterkey, err := ArmoredKeyIngest([]byte(masterkeyfile))
 			if err != nil {
-				log.Printf("gpg.Encrypt(): Unable to ingest master GPG key: %s", err.Error())
+				logger().Warn("gpg.Encrypt: unable to ingest master GPG key", "err", err)
 				el = openpgp.EntityList{key}
 			} else {
-				log.Printf("gpg.Encrypt(): Encrypting data with key %s and master key", EntityID(key))
+				logger().Debug("gpg.Encrypt: encrypting with master key", "key", EntityID(key))
 				el = openpgp.EntityList{key, masterkey}
 			}
 		}
@@ -93,10 +99,32 @@ func Encrypt(in []byte, publicKeyring openpgp.EntityList, id string, masterKeyFi
 	if err != nil {
 		return []byte{}, err
 	}
-	log.Printf("Encrypt(): Outputting %d bytes", len(buf.Bytes()))
+	logger().Debug("gpg.Encrypt: done", "bytes", buf.Len())
 	return io.ReadAll(buf)
 }
 
+// EncryptSymmetric encrypts an input byte array with a passphrase, producing
+// the same kind of message as "gpg -c". Such messages can be decrypted with
+// DecryptWithPrompt.
+func EncryptSymmetric(in []byte, passphrase []byte) ([]byte, error) {
+	logger().Debug("gpg.EncryptSymmetric", "bytes", len(in))
+
+	buf := new(bytes.Buffer)
+	w, err := openpgp.SymmetricallyEncrypt(buf, passphrase, nil, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	_, err = w.Write(in)
+	if err != nil {
+		return []byte{}, err
+	}
+	err = w.Close()
+	if err != nil {
+		return []byte{}, err
+	}
+	return buf.Bytes(), nil
+}
+
 // RawKeyData is a convenience type for []byte, used for ingesting raw GPG
 // key data.
 type RawKeyData []byte
@@ -130,7 +158,7 @@ func KeyArrayToEntityList(ka []RawKeyData) (openpgp.EntityList, error) {
 	for _, k := range ka {
 		e, err := ArmoredKeyIngest(k)
 		if err != nil {
-			log.Printf("gpg.KeyArrayToEntityList(): %s", err.Error())
+			logger().Warn("gpg.KeyArrayToEntityList: skipping key", "err", err)
 			continue
 		}
 		el = append(el, e)
@@ -0,0 +1,126 @@
+package gpg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// armorBegin starts every ASCII armored block.
+var armorBegin = []byte("-----BEGIN PGP ")
+
+// ReadKeyRing reads every entity from a keyring, such as the output of
+// "gpg --export" or "gpg --export-secret-keys", with or without --armor.
+// Several concatenated armored blocks may be present. Entities appearing
+// more than once are merged as with MergeKeyRings.
+func ReadKeyRing(in io.Reader) (openpgp.EntityList, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Contains(data, armorBegin) {
+		el, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return MergeKeyRings(el), nil
+	}
+
+	lists := make([]openpgp.EntityList, 0)
+	for {
+		start := bytes.Index(data, armorBegin)
+		if start < 0 {
+			break
+		}
+		data = data[start:]
+		// Decode only this block, so the next one is not swallowed
+		end := len(data)
+		if next := bytes.Index(data[len(armorBegin):], armorBegin); next >= 0 {
+			end = next + len(armorBegin)
+		}
+		block, err := armor.Decode(bytes.NewReader(data[:end]))
+		if err != nil {
+			return nil, err
+		}
+		data = data[end:]
+
+		switch block.Type {
+		case openpgp.PublicKeyType, openpgp.PrivateKeyType:
+			el, err := openpgp.ReadKeyRing(block.Body)
+			if err != nil {
+				return nil, err
+			}
+			lists = append(lists, el)
+		default:
+			logger().Debug("gpg.ReadKeyRing: skipping armored block", "type", block.Type)
+		}
+	}
+	el := MergeKeyRings(lists...)
+	if len(el) < 1 {
+		return el, errors.New("gpg.ReadKeyRing(): No keys found")
+	}
+	return el, nil
+}
+
+// ReadKeyRingFile reads every entity from a keyring file, as ReadKeyRing.
+func ReadKeyRingFile(path string) (openpgp.EntityList, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return ReadKeyRing(fp)
+}
+
+// MergeKeyRings combines keyrings, de-duplicating entities by fingerprint.
+// When an entity appears more than once, secret key material, subkeys and
+// identities found in any copy are merged into the first one.
+func MergeKeyRings(lists ...openpgp.EntityList) openpgp.EntityList {
+	merged := make(openpgp.EntityList, 0)
+	byFingerprint := make(map[string]*openpgp.Entity)
+	for _, el := range lists {
+		for _, e := range el {
+			existing, ok := byFingerprint[Fingerprint(e)]
+			if !ok {
+				byFingerprint[Fingerprint(e)] = e
+				merged = append(merged, e)
+				continue
+			}
+			mergeEntity(existing, e)
+		}
+	}
+	return merged
+}
+
+// mergeEntity copies secret key material, subkeys and identities missing
+// from dst out of src, which must have the same primary key.
+func mergeEntity(dst *openpgp.Entity, src *openpgp.Entity) {
+	if dst.PrivateKey == nil && src.PrivateKey != nil {
+		dst.PrivateKey = src.PrivateKey
+	}
+	for _, sub := range src.Subkeys {
+		found := false
+		for i := range dst.Subkeys {
+			if bytes.Equal(dst.Subkeys[i].PublicKey.Fingerprint, sub.PublicKey.Fingerprint) {
+				found = true
+				if dst.Subkeys[i].PrivateKey == nil && sub.PrivateKey != nil {
+					dst.Subkeys[i].PrivateKey = sub.PrivateKey
+				}
+				break
+			}
+		}
+		if !found {
+			dst.Subkeys = append(dst.Subkeys, sub)
+		}
+	}
+	for name, ident := range src.Identities {
+		if _, ok := dst.Identities[name]; !ok {
+			dst.Identities[name] = ident
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gpg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/bmizerany/assert"
+)
+
+func armoredPublicKey(t *testing.T, e *openpgp.Entity) string {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = e.Serialize(w)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	w.Close()
+	return buf.String()
+}
+
+func TestReadKeyRingArmored(t *testing.T) {
+	other, err := openpgp.NewEntity("Other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// A public export of two keys followed by the secret key of one of them
+	keyring := PUBKEYTEST + "\n" + armoredPublicKey(t, other) + "\n" + PRIVKEYTEST
+	el, err := ReadKeyRing(strings.NewReader(keyring))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, len(el), 2)
+
+	priv, err := ArmoredKeyIngest([]byte(PRIVKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, Fingerprint(el[0]), Fingerprint(priv))
+	assert.NotEqual(t, el[0].PrivateKey, nil)
+	assert.Equal(t, Fingerprint(el[1]), Fingerprint(other))
+	assert.Equal(t, el[1].PrivateKey == nil, true)
+
+	// The merged secret key is usable for decryption
+	out, err := Decrypt(encryptedTestPayload(t), el)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+}
+
+func TestReadKeyRingFileBinary(t *testing.T) {
+	var buf bytes.Buffer
+	for _, name := range []string{"One", "Two"} {
+		e, err := openpgp.NewEntity(name, "", strings.ToLower(name)+"@example.com", nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		err = e.SerializePrivate(&buf, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	path := filepath.Join(t.TempDir(), "secring.gpg")
+	err := os.WriteFile(path, buf.Bytes(), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	el, err := ReadKeyRingFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, len(el), 2)
+	assert.Equal(t, len(MergeKeyRings(el, el)), 2)
+}
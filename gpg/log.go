@@ -0,0 +1,36 @@
+package gpg
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// discardHandler is a slog.Handler which drops everything, so the package
+// is silent unless given a logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var currentLogger atomic.Pointer[slog.Logger]
+
+func init() {
+	SetLogger(nil)
+}
+
+// SetLogger sets the logger used by the package. A nil logger, the
+// default, discards everything. Key material, passphrases and plain text
+// are never logged, at any level.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(discardHandler{})
+	}
+	currentLogger.Store(l)
+}
+
+func logger() *slog.Logger {
+	return currentLogger.Load()
+}
@@ -0,0 +1,92 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bmizerany/assert"
+)
+
+func TestLoggingSilentByDefault(t *testing.T) {
+	var std bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&std)
+
+	priv, err := ArmoredKeyIngest([]byte(PRIVKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = Decrypt([]byte(ENCODEDPAYLOAD), openpgp.EntityList{priv})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, std.String(), "")
+	assert.Equal(t, logger().Enabled(context.Background(), slog.LevelError), false)
+}
+
+func TestLoggingNoSecrets(t *testing.T) {
+	var out bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.Level(-100)})))
+	defer SetLogger(nil)
+
+	pub, err := ArmoredKeyIngest([]byte(PUBKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	priv, err := ArmoredKeyIngest([]byte(PRIVKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	enc, err := Encrypt([]byte(DECODEDPAYLOAD), openpgp.EntityList{pub}, "", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = Decrypt(enc, openpgp.EntityList{priv})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = Decrypt([]byte(ENCODEDPAYLOAD), openpgp.EntityList{priv})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sym, err := EncryptSymmetric([]byte(DECODEDPAYLOAD), []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = DecryptWithPrompt(sym, nil, func(string, int) ([]byte, error) {
+		return []byte("correct horse"), nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	logged := out.String()
+	if logged == "" {
+		t.Fatal("expected debug output")
+	}
+	payload := strings.TrimSpace(DECODEDPAYLOAD)
+	secrets := []string{
+		payload[:16],
+		hex.EncodeToString([]byte(payload))[:16],
+		base64.StdEncoding.EncodeToString([]byte(payload))[:16],
+		"correct horse",
+		"PRIVATE KEY",
+	}
+	for _, line := range strings.Split(PRIVKEYTEST, "\n") {
+		if len(line) == 64 {
+			secrets = append(secrets, line[20:])
+		}
+	}
+	for _, s := range secrets {
+		if strings.Contains(logged, s) {
+			t.Errorf("log output contains %q:\n%s", s, logged)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package gpg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// pinentryCanceled is the gpg-error code returned by pinentry when the
+// user dismisses the dialog.
+const pinentryCanceled = 83886179
+
+// PinentryPrompt returns a PromptFunc which obtains the passphrase by
+// running a pinentry program (see the Assuan based pinentry protocol used
+// by GnuPG). If program is empty, "pinentry" is looked up in $PATH.
+func PinentryPrompt(program string) PromptFunc {
+	if program == "" {
+		program = "pinentry"
+	}
+	return func(desc string, attempt int) ([]byte, error) {
+		cmd := exec.Command(program)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		err = cmd.Start()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoPassphrase, err.Error())
+		}
+		defer cmd.Wait()
+		defer stdin.Close()
+
+		return pinentryGetPin(stdout, stdin, desc, attempt)
+	}
+}
+
+// pinentryGetPin runs a single GETPIN exchange over an already started
+// pinentry connection.
+func pinentryGetPin(r io.Reader, w io.Writer, desc string, attempt int) ([]byte, error) {
+	conn, err := newAssuanConn(r, w)
+	if err != nil {
+		return nil, fmt.Errorf("pinentry: %w", err)
+	}
+	defer conn.transact("BYE", nil)
+
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		_, err = conn.transact("OPTION ttyname="+assuanEscape([]byte(tty)), nil)
+		if err != nil {
+			return nil, fmt.Errorf("pinentry: %w", err)
+		}
+	}
+	commands := []string{
+		"SETTITLE git-crypt",
+		"SETDESC " + assuanEscape([]byte("Please enter the passphrase to unlock "+desc)),
+		"SETPROMPT Passphrase:",
+	}
+	if attempt > 1 {
+		commands = append(commands, "SETERROR "+assuanEscape([]byte(fmt.Sprintf("Bad passphrase (attempt %d of %d)", attempt, MaxPromptAttempts))))
+	}
+	for _, c := range commands {
+		_, err = conn.transact(c, nil)
+		if err != nil {
+			return nil, fmt.Errorf("pinentry: %w", err)
+		}
+	}
+
+	pin, err := conn.transact("GETPIN", nil)
+	if err != nil {
+		var ae *AssuanError
+		if errors.As(err, &ae) && ae.Code == pinentryCanceled {
+			return nil, fmt.Errorf("%w: pinentry canceled", ErrNoPassphrase)
+		}
+		return nil, fmt.Errorf("pinentry: %w", err)
+	}
+	return pin, nil
+}
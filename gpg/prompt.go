@@ -0,0 +1,138 @@
+package gpg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/term"
+)
+
+// MaxPromptAttempts is the number of times a PromptFunc will be asked for
+// a passphrase before decryption is abandoned.
+var MaxPromptAttempts = 3
+
+var (
+	// ErrBadPassphrase is returned when a locked private key could not be
+	// unlocked with any of the passphrases supplied by a PromptFunc.
+	ErrBadPassphrase = errors.New("gpg: bad passphrase")
+	// ErrNoPassphrase is returned by the bundled PromptFunc implementations
+	// when no passphrase source is available.
+	ErrNoPassphrase = errors.New("gpg: no passphrase available")
+)
+
+// PromptFunc is invoked when a locked private key (or a passphrase
+// encrypted message) is encountered during decryption. desc is a human
+// readable description of what the passphrase is for, and attempt starts
+// at 1 and increases with every rejected passphrase.
+type PromptFunc func(desc string, attempt int) ([]byte, error)
+
+// promptFunction adapts a PromptFunc to the callback used by
+// openpgp.ReadMessage, unlocking candidate keys in place and enforcing
+// MaxPromptAttempts.
+func promptFunction(prompt PromptFunc) openpgp.PromptFunction {
+	if prompt == nil {
+		return nil
+	}
+	attempt := 0
+	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		attempt++
+		if attempt > MaxPromptAttempts {
+			return nil, fmt.Errorf("%w (giving up after %d attempts)", ErrBadPassphrase, MaxPromptAttempts)
+		}
+
+		desc := "symmetrically encrypted message"
+		if len(keys) > 0 {
+			desc = describeKey(keys[0])
+		}
+		passphrase, err := prompt(desc, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		unlocked := false
+		for _, k := range keys {
+			if k.PrivateKey == nil || !k.PrivateKey.Encrypted {
+				continue
+			}
+			if k.PrivateKey.Decrypt(passphrase) == nil {
+				unlocked = true
+			}
+		}
+		if unlocked || !symmetric {
+			// Either a key was unlocked, or the passphrase was wrong and
+			// ReadMessage will ask us again
+			return nil, nil
+		}
+		return passphrase, nil
+	}
+}
+
+func describeKey(k openpgp.Key) string {
+	id := k.PublicKey.KeyIdString()
+	if k.Entity != nil {
+		if ident := k.Entity.PrimaryIdentity(); ident != nil {
+			return fmt.Sprintf("%s (%s)", ident.Name, id)
+		}
+	}
+	return id
+}
+
+// TerminalPrompt returns a PromptFunc which asks for the passphrase on the
+// controlling terminal without echoing it.
+func TerminalPrompt() PromptFunc {
+	return func(desc string, attempt int) ([]byte, error) {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return nil, fmt.Errorf("%w: no terminal", ErrNoPassphrase)
+			}
+			tty = os.Stdin
+		} else {
+			defer tty.Close()
+		}
+		if attempt > 1 {
+			fmt.Fprintf(os.Stderr, "Bad passphrase (attempt %d of %d)\n", attempt, MaxPromptAttempts)
+		}
+		fmt.Fprintf(os.Stderr, "Passphrase for %s: ", desc)
+		passphrase, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+}
+
+// EnvPrompt returns a PromptFunc which reads the passphrase from the named
+// environment variable. Since the value cannot change between attempts, a
+// rejected passphrase fails immediately.
+func EnvPrompt(name string) PromptFunc {
+	return func(desc string, attempt int) ([]byte, error) {
+		if attempt > 1 {
+			return nil, fmt.Errorf("%w: passphrase from $%s rejected for %s", ErrBadPassphrase, name, desc)
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: $%s is not set", ErrNoPassphrase, name)
+		}
+		return []byte(v), nil
+	}
+}
+
+// FilePrompt returns a PromptFunc which reads the passphrase from the first
+// line of a file. Since the value cannot change between attempts, a rejected
+// passphrase fails immediately.
+func FilePrompt(path string) PromptFunc {
+	return func(desc string, attempt int) ([]byte, error) {
+		if attempt > 1 {
+			return nil, fmt.Errorf("%w: passphrase from %s rejected for %s", ErrBadPassphrase, path, desc)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoPassphrase, err.Error())
+		}
+		line, _, _ := bytes.Cut(raw, []byte("\n"))
+		return []byte(strings.TrimSuffix(string(line), "\r")), nil
+	}
+}
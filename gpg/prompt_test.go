@@ -0,0 +1,164 @@
+package gpg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bmizerany/assert"
+)
+
+func lockedTestKey(t *testing.T, passphrase string) openpgp.EntityList {
+	priv, err := ArmoredKeyIngest([]byte(PRIVKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = priv.EncryptPrivateKeys([]byte(passphrase), nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return openpgp.EntityList{priv}
+}
+
+func encryptedTestPayload(t *testing.T) []byte {
+	pub, err := ArmoredKeyIngest([]byte(PUBKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	enc, err := Encrypt([]byte(DECODEDPAYLOAD), openpgp.EntityList{pub}, "", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return enc
+}
+
+func TestDecryptWithPrompt(t *testing.T) {
+	enc := encryptedTestPayload(t)
+	keyring := lockedTestKey(t, "sekrit")
+
+	_, err := Decrypt(enc, keyring)
+	if err == nil {
+		t.Fatal("expected locked key to fail without a prompt")
+	}
+
+	calls := 0
+	out, err := DecryptWithPrompt(enc, keyring, func(desc string, attempt int) ([]byte, error) {
+		calls++
+		if attempt == 1 {
+			return []byte("wrong"), nil
+		}
+		return []byte("sekrit"), nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+	assert.Equal(t, calls, 2)
+}
+
+func TestDecryptWithPromptRetryLimit(t *testing.T) {
+	enc := encryptedTestPayload(t)
+	keyring := lockedTestKey(t, "sekrit")
+
+	calls := 0
+	_, err := DecryptWithPrompt(enc, keyring, func(desc string, attempt int) ([]byte, error) {
+		calls++
+		return []byte("wrong"), nil
+	})
+	if !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("expected ErrBadPassphrase, got %v", err)
+	}
+	assert.Equal(t, calls, MaxPromptAttempts)
+}
+
+func TestDecryptSymmetric(t *testing.T) {
+	enc, err := EncryptSymmetric([]byte(DECODEDPAYLOAD), []byte("sekrit"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	calls := 0
+	out, err := DecryptWithPrompt(enc, nil, func(desc string, attempt int) ([]byte, error) {
+		calls++
+		assert.Equal(t, desc, "symmetrically encrypted message")
+		if attempt == 1 {
+			return []byte("wrong"), nil
+		}
+		return []byte("sekrit"), nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+	assert.Equal(t, calls, 2)
+}
+
+func TestEnvPrompt(t *testing.T) {
+	enc := encryptedTestPayload(t)
+	t.Setenv("GITCRYPT_TEST_PASSPHRASE", "sekrit")
+	out, err := DecryptWithPrompt(enc, lockedTestKey(t, "sekrit"), EnvPrompt("GITCRYPT_TEST_PASSPHRASE"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+
+	t.Setenv("GITCRYPT_TEST_PASSPHRASE", "wrong")
+	_, err = DecryptWithPrompt(enc, lockedTestKey(t, "sekrit"), EnvPrompt("GITCRYPT_TEST_PASSPHRASE"))
+	if !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("expected ErrBadPassphrase, got %v", err)
+	}
+
+	_, err = EnvPrompt("GITCRYPT_TEST_UNSET")("key", 1)
+	if !errors.Is(err, ErrNoPassphrase) {
+		t.Fatalf("expected ErrNoPassphrase, got %v", err)
+	}
+}
+
+// fakePinentry speaks just enough of the pinentry protocol to answer a
+// single GETPIN.
+func fakePinentry(r io.Reader, w io.Writer, pin string, seen *[]string) {
+	in := bufio.NewScanner(r)
+	io.WriteString(w, "OK Pleased to meet you\n")
+	for in.Scan() {
+		line := in.Text()
+		*seen = append(*seen, line)
+		switch {
+		case line == "GETPIN":
+			io.WriteString(w, "D "+assuanEscape([]byte(pin))+"\nOK\n")
+		case line == "BYE":
+			io.WriteString(w, "OK closing connection\n")
+			return
+		default:
+			io.WriteString(w, "OK\n")
+		}
+	}
+}
+
+func TestPinentryGetPin(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	var seen []string
+	done := make(chan struct{})
+	go func() {
+		fakePinentry(sr, sw, "pass%word\n", &seen)
+		close(done)
+	}()
+
+	pin, err := pinentryGetPin(cr, cw, "Test GPG Key", 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	<-done
+	assert.Equal(t, string(pin), "pass%word\n")
+
+	sawError := false
+	for _, l := range seen {
+		if strings.HasPrefix(l, "SETERROR ") {
+			sawError = true
+		}
+	}
+	assert.Equal(t, sawError, true)
+}
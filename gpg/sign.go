@@ -0,0 +1,125 @@
+package gpg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+var (
+	// ErrUnsigned is returned by the verifying decryption functions when a
+	// message carries no signature.
+	ErrUnsigned = errors.New("gpg: message is not signed")
+	// ErrUntrustedSigner is returned by the verifying decryption functions
+	// when a message is signed by a key which is not trusted.
+	ErrUntrustedSigner = errors.New("gpg: message is signed by an untrusted key")
+	// ErrBadSignature is returned by the verifying decryption functions
+	// when a message signature does not verify.
+	ErrBadSignature = errors.New("gpg: bad signature")
+)
+
+// EncryptSigned encrypts an input byte array for recipients and signs it
+// with signer, whose secret key is unlocked with prompt if it is locked.
+func EncryptSigned(in []byte, recipients openpgp.EntityList, signer *openpgp.Entity, prompt PromptFunc) ([]byte, error) {
+	logger().Debug("gpg.EncryptSigned", "bytes", len(in), "signer", EntityID(signer))
+
+	err := unlockSigner(signer, prompt)
+	if err != nil {
+		return []byte{}, err
+	}
+	buf := new(bytes.Buffer)
+	w, err := openpgp.Encrypt(buf, recipients, signer, nil, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	_, err = w.Write(in)
+	if err != nil {
+		return []byte{}, err
+	}
+	err = w.Close()
+	if err != nil {
+		return []byte{}, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptVerified decrypts an input byte array like DecryptWithPrompt, and
+// additionally requires it to carry a valid signature by one of the keys
+// in trusted, which is returned.
+func DecryptVerified(in []byte, secretKeyring openpgp.EntityList, trusted openpgp.EntityList, prompt PromptFunc) ([]byte, *openpgp.Entity, error) {
+	logger().Debug("gpg.DecryptVerified", "bytes", len(in))
+
+	var r io.Reader = bytes.NewReader(in)
+	if strings.Contains(string(in), "BEGIN PGP MESSAGE") {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return []byte{}, nil, err
+		}
+		r = block.Body
+	}
+	md, err := openpgp.ReadMessage(r, append(append(openpgp.EntityList{}, secretKeyring...), trusted...), promptFunction(prompt), nil)
+	if err != nil {
+		return []byte{}, nil, err
+	}
+	return verifyMessage(md, trusted)
+}
+
+// verifyMessage reads the body of a message, which must be signed by one
+// of the keys in trusted. The signature is only checked once the whole
+// body has been read.
+func verifyMessage(md *openpgp.MessageDetails, trusted openpgp.EntityList) ([]byte, *openpgp.Entity, error) {
+	out, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return []byte{}, nil, err
+	}
+	if !md.IsSigned {
+		return []byte{}, nil, ErrUnsigned
+	}
+	var signer *openpgp.Entity
+	for _, e := range trusted {
+		if md.SignedBy != nil && md.SignedBy.Entity != nil && Fingerprint(e) == Fingerprint(md.SignedBy.Entity) {
+			signer = e
+			break
+		}
+	}
+	if signer == nil {
+		return []byte{}, nil, fmt.Errorf("%w (key ID %016X)", ErrUntrustedSigner, md.SignedByKeyId)
+	}
+	if md.SignatureError != nil {
+		return []byte{}, nil, fmt.Errorf("%w: %s", ErrBadSignature, md.SignatureError.Error())
+	}
+	return out, signer, nil
+}
+
+// unlockSigner decrypts the signing secret keys of an entity, asking
+// prompt for the passphrase up to MaxPromptAttempts times.
+func unlockSigner(e *openpgp.Entity, prompt PromptFunc) error {
+	if e.PrivateKey == nil {
+		return errors.New("gpg: signing key " + EntityID(e) + " has no secret key")
+	}
+	if !e.PrivateKey.Encrypted {
+		return nil
+	}
+	if prompt == nil {
+		return fmt.Errorf("%w: signing key %s is locked", ErrNoPassphrase, EntityID(e))
+	}
+	desc := e.PrimaryKey.KeyIdString()
+	if ident := e.PrimaryIdentity(); ident != nil {
+		desc = fmt.Sprintf("%s (%s)", ident.Name, desc)
+	}
+	for attempt := 1; attempt <= MaxPromptAttempts; attempt++ {
+		passphrase, err := prompt(desc, attempt)
+		if err != nil {
+			return err
+		}
+		if e.DecryptPrivateKeys(passphrase) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w (giving up after %d attempts)", ErrBadPassphrase, MaxPromptAttempts)
+}
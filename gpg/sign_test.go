@@ -0,0 +1,78 @@
+package gpg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bmizerany/assert"
+)
+
+func TestDecryptVerified(t *testing.T) {
+	recipient, err := ArmoredKeyIngest([]byte(PRIVKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	signer, err := openpgp.NewEntity("Signer", "", "signer@example.com", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	other, err := openpgp.NewEntity("Other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	signed, err := EncryptSigned([]byte(DECODEDPAYLOAD), openpgp.EntityList{recipient}, signer, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	out, by, err := DecryptVerified(signed, openpgp.EntityList{recipient}, openpgp.EntityList{other, signer}, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+	assert.Equal(t, Fingerprint(by), Fingerprint(signer))
+
+	// Signed, but not by a trusted key
+	_, _, err = DecryptVerified(signed, openpgp.EntityList{recipient}, openpgp.EntityList{other}, nil)
+	if !errors.Is(err, ErrUntrustedSigner) {
+		t.Errorf("expected ErrUntrustedSigner, got %v", err)
+	}
+
+	// Not signed at all
+	_, _, err = DecryptVerified(encryptedTestPayload(t), openpgp.EntityList{recipient}, openpgp.EntityList{signer}, nil)
+	if !errors.Is(err, ErrUnsigned) {
+		t.Errorf("expected ErrUnsigned, got %v", err)
+	}
+
+	// Unverified decryption still works
+	out, err = Decrypt(signed, openpgp.EntityList{recipient})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, string(out), DECODEDPAYLOAD)
+}
+
+func TestEncryptSignedLockedSigner(t *testing.T) {
+	signer := lockedTestKey(t, "sekrit")[0]
+	recipient, err := openpgp.NewEntity("Recipient", "", "recipient@example.com", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = EncryptSigned([]byte(DECODEDPAYLOAD), openpgp.EntityList{recipient}, signer, nil)
+	if !errors.Is(err, ErrNoPassphrase) {
+		t.Fatalf("expected ErrNoPassphrase, got %v", err)
+	}
+	signed, err := EncryptSigned([]byte(DECODEDPAYLOAD), openpgp.EntityList{recipient}, signer, func(desc string, attempt int) ([]byte, error) {
+		return []byte("sekrit"), nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, by, err := DecryptVerified(signed, openpgp.EntityList{recipient}, openpgp.EntityList{signer}, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, Fingerprint(by), Fingerprint(signer))
+}
@@ -0,0 +1,145 @@
+package gpg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// KeyPolicy describes which keys are acceptable as recipients of a
+// repository key.
+type KeyPolicy struct {
+	// MinRSABits is the minimum size of RSA keys
+	MinRSABits int
+	// AllowedAlgorithms lists the acceptable public key algorithms for
+	// the primary key and the encryption key
+	AllowedAlgorithms []packet.PublicKeyAlgorithm
+	// Now is the time at which expiry and revocation are evaluated. If it
+	// is zero, the current time is used.
+	Now time.Time
+}
+
+// DefaultKeyPolicy accepts RSA keys of at least 2048 bits and elliptic
+// curve keys, rejecting DSA and ElGamal.
+var DefaultKeyPolicy = KeyPolicy{
+	MinRSABits: 2048,
+	AllowedAlgorithms: []packet.PublicKeyAlgorithm{
+		packet.PubKeyAlgoRSA,
+		packet.PubKeyAlgoRSAEncryptOnly,
+		packet.PubKeyAlgoRSASignOnly,
+		packet.PubKeyAlgoECDH,
+		packet.PubKeyAlgoECDSA,
+		packet.PubKeyAlgoEdDSA,
+		packet.PubKeyAlgoX25519,
+		packet.PubKeyAlgoX448,
+		packet.PubKeyAlgoEd25519,
+		packet.PubKeyAlgoEd448,
+	},
+}
+
+// KeyValidationError reports why a key is not acceptable as a recipient.
+type KeyValidationError struct {
+	Fingerprint string
+	Identity    string
+	Problems    []string
+}
+
+func (e *KeyValidationError) Error() string {
+	return fmt.Sprintf("gpg: key %s (%s) is not a valid recipient: %s", e.Fingerprint, e.Identity, strings.Join(e.Problems, "; "))
+}
+
+// ValidateRecipient checks that an entity is safe to encrypt repository
+// keys to: it must carry valid self-signatures, must not be expired or
+// revoked, must have an encryption-capable key, and its keys must satisfy
+// policy. A nil policy selects DefaultKeyPolicy. All problems found are
+// reported together in a *KeyValidationError.
+func ValidateRecipient(e *openpgp.Entity, policy *KeyPolicy) error {
+	if policy == nil {
+		policy = &DefaultKeyPolicy
+	}
+	now := policy.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	verr := &KeyValidationError{Fingerprint: Fingerprint(e)}
+	problem := func(format string, args ...interface{}) {
+		verr.Problems = append(verr.Problems, fmt.Sprintf(format, args...))
+	}
+
+	validIdentities := 0
+	for name, ident := range e.Identities {
+		if verr.Identity == "" {
+			verr.Identity = name
+		}
+		if ident.SelfSignature == nil || e.PrimaryKey.VerifyUserIdSignature(name, e.PrimaryKey, ident.SelfSignature) != nil {
+			problem("identity %q has no valid self-signature", name)
+			continue
+		}
+		validIdentities++
+	}
+	if ident := e.PrimaryIdentity(); ident != nil {
+		verr.Identity = ident.Name
+	}
+	if validIdentities == 0 {
+		problem("no self-signed identity")
+	}
+
+	selfSig, ident := e.PrimarySelfSignature()
+	switch {
+	case selfSig == nil:
+		problem("no valid self-signature")
+	case e.PrimaryKey.CreationTime.After(now):
+		problem("created in the future")
+	case e.PrimaryKey.KeyExpired(selfSig, now):
+		problem("expired on %s", e.PrimaryKey.CreationTime.Add(time.Duration(*selfSig.KeyLifetimeSecs)*time.Second).Format("2006-01-02"))
+	case selfSig.SigExpired(now):
+		problem("self-signature expired")
+	}
+	if e.Revoked(now) {
+		problem("revoked")
+	} else if ident != nil && ident.Revoked(now) {
+		problem("primary identity %q revoked", ident.Name)
+	}
+
+	checkKey := func(what string, pk *packet.PublicKey) {
+		if !algorithmAllowed(pk.PubKeyAlgo, policy.AllowedAlgorithms) {
+			problem("%s uses disallowed algorithm %d", what, pk.PubKeyAlgo)
+		}
+		switch pk.PubKeyAlgo {
+		case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoRSASignOnly:
+			bits, err := pk.BitLength()
+			if err == nil && int(bits) < policy.MinRSABits {
+				problem("%s is RSA %d, below minimum of %d bits", what, bits, policy.MinRSABits)
+			}
+		}
+	}
+	checkKey("primary key", e.PrimaryKey)
+
+	if key, ok := e.EncryptionKey(now); ok {
+		if key.PublicKey != e.PrimaryKey {
+			checkKey("encryption subkey "+key.PublicKey.KeyIdString(), key.PublicKey)
+		}
+	} else if len(verr.Problems) == 0 {
+		// Expiry and revocation also leave no usable encryption key, so
+		// only report this on its own
+		problem("no valid encryption-capable key")
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func algorithmAllowed(algo packet.PublicKeyAlgorithm, allowed []packet.PublicKeyAlgorithm) bool {
+	for _, a := range allowed {
+		if a == algo {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package gpg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/bmizerany/assert"
+)
+
+func validationProblems(t *testing.T, e *openpgp.Entity, policy *KeyPolicy) string {
+	err := ValidateRecipient(e, policy)
+	if err == nil {
+		return ""
+	}
+	var verr *KeyValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected KeyValidationError, got %v", err)
+	}
+	assert.Equal(t, verr.Fingerprint, Fingerprint(e))
+	return strings.Join(verr.Problems, "; ")
+}
+
+func TestValidateRecipient(t *testing.T) {
+	pub, err := ArmoredKeyIngest([]byte(PUBKEYTEST))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, validationProblems(t, pub, nil), "")
+
+	// A stricter policy rejects the 2048 bit test key
+	problems := validationProblems(t, pub, &KeyPolicy{MinRSABits: 3072, AllowedAlgorithms: DefaultKeyPolicy.AllowedAlgorithms})
+	assert.Equal(t, strings.Contains(problems, "below minimum of 3072 bits"), true)
+
+	ec, err := openpgp.NewEntity("EC", "", "ec@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, validationProblems(t, ec, nil), "")
+	problems = validationProblems(t, ec, &KeyPolicy{AllowedAlgorithms: []packet.PublicKeyAlgorithm{packet.PubKeyAlgoRSA}})
+	assert.Equal(t, strings.Contains(problems, "disallowed algorithm"), true)
+
+	ec.Subkeys = nil
+	assert.Equal(t, validationProblems(t, ec, nil), "no valid encryption-capable key")
+}
+
+func TestValidateRecipientExpiredRevoked(t *testing.T) {
+	expiring, err := openpgp.NewEntity("Expiring", "", "expiring@example.com", &packet.Config{
+		Algorithm:       packet.PubKeyAlgoEdDSA,
+		KeyLifetimeSecs: 3600,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, validationProblems(t, expiring, nil), "")
+	problems := validationProblems(t, expiring, &KeyPolicy{Now: time.Now().Add(2 * time.Hour), AllowedAlgorithms: DefaultKeyPolicy.AllowedAlgorithms})
+	assert.Equal(t, strings.HasPrefix(problems, "expired on "), true)
+
+	revoked, err := openpgp.NewEntity("Revoked", "", "revoked@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = revoked.RevokeKey(packet.KeyCompromised, "lost laptop", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, validationProblems(t, revoked, nil), "revoked")
+}
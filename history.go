@@ -0,0 +1,169 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HistoryLeak is a file committed in plain text although .gitattributes
+// at that commit marked it for encryption. Its contents should be treated
+// as exposed, and any secrets in it rotated.
+type HistoryLeak struct {
+	Commit string
+	Path   string
+	Blob   string
+	// Author is the author of the commit, as "Name <email>"
+	Author string
+	// KeyName is the key the file should have been encrypted with
+	KeyName string
+}
+
+// ScanHistory walks every commit reachable from refs, or from all refs if
+// none are given, and reports files which .gitattributes at that commit
+// marked for encryption but which were stored in plain text. Each leaked
+// blob is reported once per path, at the oldest commit where it leaked.
+func (g *GitCrypt) ScanHistory(repoPath string, refs []string) ([]HistoryLeak, error) {
+	args := make([]string, 0)
+	if len(refs) == 0 {
+		args = append(args, "--all")
+	}
+	commits, err := gitLogCommits(repoPath, append(args, refs...)...)
+	if err != nil {
+		return nil, err
+	}
+	return g.scanCommits(repoPath, commits)
+}
+
+// historyCommit is a commit and its author.
+type historyCommit struct {
+	ID     string
+	Author string
+}
+
+// gitLogCommits lists the commits selected by revision arguments, parents
+// before children.
+func gitLogCommits(repoPath string, revs ...string) ([]historyCommit, error) {
+	args := append([]string{"log", "--reverse", "--topo-order", "--format=%H%x00%an <%ae>"}, revs...)
+	out, err := gitCommand(repoPath, nil, append(args, "--")...)
+	if err != nil {
+		return nil, err
+	}
+	commits := make([]historyCommit, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		id, author, ok := strings.Cut(line, "\x00")
+		if ok {
+			commits = append(commits, historyCommit{ID: id, Author: author})
+		}
+	}
+	return commits, nil
+}
+
+// scanCommits reports the files leaked in plain text by commits, which
+// must be ordered parents first.
+func (g *GitCrypt) scanCommits(repoPath string, commits []historyCommit) ([]HistoryLeak, error) {
+	leaks := make([]HistoryLeak, 0)
+	if len(commits) == 0 {
+		return leaks, nil
+	}
+
+	// Attributes are evaluated against a scratch index holding each commit,
+	// as check-attr cannot read them from a tree before git 2.40.
+	tmp, err := os.MkdirTemp("", "go-git-crypt-scan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")}
+
+	blobs, err := newGitBlobReader(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer blobs.Close()
+
+	checked := make(map[string]bool)
+	leaked := make(map[string]bool)
+	loaded := ""
+	for _, c := range commits {
+		commit := c.ID
+		files, err := gitListFiles(repoPath, commit)
+		if err != nil {
+			return leaks, err
+		}
+
+		// Only files not already checked under the same attributes need
+		// looking at again
+		signature := attributesSignature(files)
+		candidates := make(map[string]gitFile)
+		paths := make([]string, 0)
+		for _, f := range files {
+			id := signature + "\x00" + f.Path + "\x00" + f.Blob
+			if checked[id] {
+				continue
+			}
+			checked[id] = true
+			candidates[f.Path] = f
+			paths = append(paths, f.Path)
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		if loaded != signature {
+			_, err = gitCommandEnv(repoPath, env, nil, "read-tree", commit)
+			if err != nil {
+				return leaks, err
+			}
+			loaded = signature
+		}
+		attrs, err := gitFilterAttributes(repoPath, env, paths, true)
+		if err != nil {
+			return leaks, err
+		}
+
+		sort.Strings(paths)
+		for _, p := range paths {
+			keyName, filtered := filterKeyName(attrs[p])
+			if !filtered {
+				continue
+			}
+			f := candidates[p]
+			if leaked[p+"\x00"+f.Blob] {
+				continue
+			}
+			data, err := blobs.Read(f.Blob)
+			if err != nil {
+				return leaks, err
+			}
+			if len(data) == 0 || bytes.HasPrefix(data, gitCryptHeader) {
+				continue
+			}
+			leaked[p+"\x00"+f.Blob] = true
+			g.logger().Debug("plain text in history", "commit", commit, "path", p)
+			leaks = append(leaks, HistoryLeak{
+				Commit:  commit,
+				Path:    p,
+				Blob:    f.Blob,
+				Author:  c.Author,
+				KeyName: keyName,
+			})
+		}
+	}
+	return leaks, nil
+}
+
+// attributesSignature identifies the .gitattributes files in a tree, so
+// commits sharing them can share attribute lookups.
+func attributesSignature(files []gitFile) string {
+	var b strings.Builder
+	for _, f := range files {
+		if path.Base(f.Path) == ".gitattributes" {
+			b.WriteString(f.Path + ":" + f.Blob + ";")
+		}
+	}
+	return b.String()
+}
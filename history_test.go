@@ -0,0 +1,62 @@
+package gitcrypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ScanHistory(t *testing.T) {
+	key := testRepoKey(t, "")
+
+	// The secret is committed before encryption is configured for it
+	repo := testGitRepo(t, map[string][]byte{
+		"README":     []byte("hello"),
+		"secret.env": []byte("PASSWORD=hunter2"),
+	}, true)
+	testWriteFiles(t, repo, map[string][]byte{
+		".gitattributes": []byte("*.env filter=git-crypt diff=git-crypt\n"),
+	})
+	testGit(t, repo, "add", ".")
+	testGit(t, repo, "commit", "-q", "-m", "encrypt env files")
+	leakedAt := strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD"))
+
+	testWriteFiles(t, repo, map[string][]byte{
+		"secret.env": testEncrypt(t, key, "PASSWORD=correct horse"),
+		"other.env":  testEncrypt(t, key, "TOKEN=abc"),
+	})
+	testGit(t, repo, "add", ".")
+	testGit(t, repo, "commit", "-q", "-m", "rotate")
+
+	// A plain text secret on another branch
+	testGit(t, repo, "checkout", "-q", "-b", "feature")
+	testWriteFiles(t, repo, map[string][]byte{
+		"feature.env": []byte("KEY=oops"),
+	})
+	testGit(t, repo, "add", ".")
+	testGit(t, repo, "commit", "-q", "-m", "feature")
+	testGit(t, repo, "checkout", "-q", "-")
+
+	g := GitCrypt{}
+	leaks, err := g.ScanHistory(repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaks) != 2 {
+		t.Fatalf("expected 2 leaks, got %v", leaks)
+	}
+	if leaks[0].Path != "secret.env" || leaks[0].Commit != leakedAt || leaks[0].Author != "test <test@example.com>" {
+		t.Errorf("unexpected leak %+v", leaks[0])
+	}
+	if leaks[1].Path != "feature.env" {
+		t.Errorf("unexpected leak %+v", leaks[1])
+	}
+
+	// Only the current branch
+	leaks, err = g.ScanHistory(repo, []string{"HEAD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaks) != 1 || leaks[0].Path != "secret.env" {
+		t.Errorf("expected only secret.env, got %v", leaks)
+	}
+}
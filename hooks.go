@@ -0,0 +1,107 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hookMarker marks hooks written by InstallHook, which may be replaced.
+const hookMarker = "# Installed by go-git-crypt"
+
+// StagedFile is a staged file which should be encrypted but is not.
+type StagedFile struct {
+	Path string
+	// KeyName is the key the file should be encrypted with
+	KeyName string
+}
+
+// CheckStaged returns the files staged for the next commit which
+// .gitattributes marks for encryption, but which are staged in plain text,
+// typically because the git-crypt filter is not configured in this clone.
+// Only files which differ from HEAD are checked.
+func (g *GitCrypt) CheckStaged(repoPath string) ([]StagedFile, error) {
+	out, err := gitCommand(repoPath, nil, "diff", "--cached", "--name-only", "-z", "--no-renames", "--diff-filter=ACMRT")
+	if err != nil {
+		return nil, err
+	}
+	staged := make(map[string]bool)
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			staged[p] = true
+		}
+	}
+	if len(staged) == 0 {
+		return []StagedFile{}, nil
+	}
+
+	index, err := gitListFiles(repoPath, "")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]gitFile, 0)
+	paths := make([]string, 0)
+	for _, f := range index {
+		if staged[f.Path] {
+			files = append(files, f)
+			paths = append(paths, f.Path)
+		}
+	}
+	attrs, err := gitFilterAttributes(repoPath, nil, paths, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs *gitBlobReader
+	plain := make([]StagedFile, 0)
+	for _, f := range files {
+		keyName, filtered := filterKeyName(attrs[f.Path])
+		if !filtered {
+			continue
+		}
+		if blobs == nil {
+			blobs, err = newGitBlobReader(repoPath)
+			if err != nil {
+				return nil, err
+			}
+			defer blobs.Close()
+		}
+		data, err := blobs.Read(f.Blob)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 && !bytes.HasPrefix(data, gitCryptHeader) {
+			plain = append(plain, StagedFile{Path: f.Path, KeyName: keyName})
+		}
+	}
+	sort.Slice(plain, func(i, j int) bool { return plain[i].Path < plain[j].Path })
+	return plain, nil
+}
+
+// InstallHook installs a git hook in a repository which runs
+// "go-git-crypt <hook>". An existing hook is only replaced if it was
+// installed by go-git-crypt, or if force is set.
+func (g *GitCrypt) InstallHook(repoPath string, hook string, force bool) (string, error) {
+	out, err := gitCommand(repoPath, nil, "rev-parse", "--git-path", "hooks/"+hook)
+	if err != nil {
+		return "", err
+	}
+	hookPath := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(hookPath) {
+		hookPath = filepath.Join(repoPath, hookPath)
+	}
+
+	existing, err := os.ReadFile(hookPath)
+	if err == nil && !force && !bytes.Contains(existing, []byte(hookMarker)) {
+		return hookPath, fmt.Errorf("%s already exists; not replacing it", hookPath)
+	}
+	err = os.MkdirAll(filepath.Dir(hookPath), 0755)
+	if err != nil {
+		return hookPath, err
+	}
+	script := fmt.Sprintf("#!/bin/sh\n%s\nexec go-git-crypt %s \"$@\"\n", hookMarker, hook)
+	return hookPath, os.WriteFile(hookPath, []byte(script), 0755)
+}
@@ -0,0 +1,74 @@
+package gitcrypt
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_CheckStaged(t *testing.T) {
+	key := testRepoKey(t, "")
+	repo := testGitRepo(t, map[string][]byte{
+		".gitattributes": []byte("*.env filter=git-crypt\ninfra/* filter=git-crypt-infra\n"),
+		"README":         []byte("hello"),
+		"old.env":        []byte("committed before the hook"),
+	}, true)
+
+	g := GitCrypt{}
+	staged, err := g.CheckStaged(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 0 {
+		t.Errorf("expected nothing staged, got %v", staged)
+	}
+
+	testWriteFiles(t, repo, map[string][]byte{
+		"README":      []byte("changed"),
+		"good.env":    testEncrypt(t, key, "TOKEN=abc"),
+		"empty.env":   []byte{},
+		"bad.env":     []byte("TOKEN=abc"),
+		"infra/state": []byte("plain"),
+	})
+	testGit(t, repo, "add", ".")
+	staged, err = g.CheckStaged(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 2 || staged[0].Path != "bad.env" || staged[1].Path != "infra/state" || staged[1].KeyName != "infra" {
+		t.Errorf("unexpected staged files %v", staged)
+	}
+}
+
+func Test_InstallHook(t *testing.T) {
+	repo := testGitRepo(t, map[string][]byte{"README": []byte("hello")}, false)
+
+	g := GitCrypt{}
+	path, err := g.InstallHook(repo, "pre-commit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&0100 == 0 {
+		t.Error("hook is not executable")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "go-git-crypt pre-commit") {
+		t.Errorf("unexpected hook %s", data)
+	}
+
+	// Our own hook may be replaced, others only with force
+	if _, err = g.InstallHook(repo, "pre-commit", false); err != nil {
+		t.Error(err)
+	}
+	os.WriteFile(path, []byte("#!/bin/sh\nexit 0\n"), 0755)
+	if _, err = g.InstallHook(repo, "pre-commit", false); err == nil {
+		t.Error("replaced a foreign hook")
+	}
+	if _, err = g.InstallHook(repo, "pre-commit", true); err != nil {
+		t.Error(err)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 )
 
@@ -18,7 +17,9 @@ func (g *GitCrypt) KeyFromFile(filename string) (Key, error) {
 
 // Key is a git-crypt key structure
 type Key struct {
-	Parent  *GitCrypt
+	Parent *GitCrypt
+	// Version is the key file format version read by Load, not a key
+	// version; those are the versions of the Entries.
 	Version uint32
 	Entries []KeyEntry
 	KeyName string
@@ -43,6 +44,29 @@ func (k *Key) Get(version uint32) (KeyEntry, error) {
 	return KeyEntry{}, fmt.Errorf("not found")
 }
 
+// Lock locks the key material of every entry into memory, as
+// KeyEntry.Lock, returning the first error. The memory stays locked until
+// the process exits.
+func (k *Key) Lock() error {
+	for i := range k.Entries {
+		err := k.Entries[i].Lock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Destroy wipes the key material of every entry and removes them. Copies
+// of the key share its key material, so none of them may be used
+// afterwards.
+func (k *Key) Destroy() {
+	for i := range k.Entries {
+		k.Entries[i].Destroy()
+	}
+	k.Entries = nil
+}
+
 // LoadFromFile loads a key from a filesystem file
 func (k *Key) LoadFromFile(filename string) error {
 	if !k.Parent.fileExists(filename) {
@@ -56,36 +80,42 @@ func (k *Key) LoadFromFile(filename string) error {
 	return k.Load(fp)
 }
 
-// Load imports a key from an io.Reader
+// Load imports a key from an io.Reader. Parse errors are an
+// *ErrMalformedKey or an *ErrIncompatibleVersion.
 func (k *Key) Load(in io.Reader) error {
-	preamble, err := readXBytes(in, 16)
+	r := newOffsetReader(in)
+	preamble, err := readXBytes(r, 16)
 	if err != nil {
-		return err
+		return malformed("preamble", 0)
 	}
-	if preamble[0] != byte(0) && !bytes.Equal(preamble[1:13], []byte("GITCRYPTKEY")) {
-		return fmt.Errorf("malformed preamble")
+	if !bytes.Equal(preamble[0:12], []byte("\x00GITCRYPTKEY")) {
+		return malformed("preamble", 0)
 	}
 	format, err := readBigEndianUint32(bytes.NewBuffer(preamble[12:]))
 	if err != nil {
 		return err
 	}
 	if format != formatVersion {
-		return fmt.Errorf("incompatible version %d", format)
+		return &ErrIncompatibleVersion{Got: format}
 	}
 	k.Version = format
-	if k.Debug {
-		log.Printf("format: %x", format)
-	}
-	err = k.loadHeader(in)
+	k.logger().Debug("loading key", "format", format)
+	err = k.loadHeader(r)
 	if err != nil {
-		return fmt.Errorf("LoadHeader: %s", err.Error())
+		return err
 	}
 	k.Entries = make([]KeyEntry, 0)
 	for {
+		start := r.offset
 		entry := KeyEntry{}
-		err = entry.Load(in)
+		err = entry.Load(r)
 		if err != nil {
-			break
+			var m *ErrMalformedKey
+			if errors.As(err, &m) && m.Offset == start && r.offset == start {
+				// Nothing left to read
+				break
+			}
+			return err
 		}
 		k.Entries = append(k.Entries, entry)
 	}
@@ -105,9 +135,6 @@ func (k Key) Store(out io.Writer) error {
 	writeBigEndianUint32(out, formatVersion)
 
 	if k.KeyName != "" {
-		if k.Debug {
-			log.Printf("Store: KeyName = %s", k.KeyName)
-		}
 		err = writeBigEndianUint32(out, headerFieldKeyName)
 		if err != nil {
 			return err
@@ -124,9 +151,7 @@ func (k Key) Store(out io.Writer) error {
 	}
 	_ = writeBigEndianUint32(out, headerFieldEnd)
 	for _, e := range k.Entries {
-		if k.Debug {
-			log.Printf("Store: Write Entry: %#v", e)
-		}
+		k.logger().Debug("storing key entry", "key", keyDisplayName(k.KeyName), "version", e.Version)
 		err = e.Store(out)
 		if err != nil {
 			return err
@@ -135,24 +160,25 @@ func (k Key) Store(out io.Writer) error {
 	return nil
 }
 
-func (k *Key) loadHeader(in io.Reader) error {
+func (k *Key) loadHeader(in *offsetReader) error {
 	for {
+		start := in.offset
 		fieldID, err := readBigEndianUint32(in)
 		if err != nil {
-			return errors.New("malformed")
+			return malformed("header field", start)
 		}
 		if fieldID == headerFieldEnd {
 			break
 		}
 		fieldLen, err := readBigEndianUint32(in)
 		if err != nil {
-			return errors.New("malformed")
+			return malformed("header field length", start)
 		}
 
 		switch fieldID {
 		case headerFieldKeyName:
 			if fieldLen > keyNameMaxLength {
-				return errors.New("malformed")
+				return malformed("key name length", start)
 			}
 			if fieldLen == 0 {
 				// special case field_len==0 to avoid possible undefined behavior
@@ -162,25 +188,25 @@ func (k *Key) loadHeader(in io.Reader) error {
 				raw, err := readXBytes(in, int(fieldLen))
 				if err != nil {
 					k.KeyName = ""
-					return errors.New("malformed")
+					return malformed("key name", start)
 				}
 				k.KeyName = string(raw)
 				err = validateKeyName(k.KeyName)
 				if err != nil {
 					k.KeyName = ""
-					return errors.New("malformed")
+					return malformed("key name", start)
 				}
 			}
 		case fieldID & 1:
-			return errors.New("incompatible")
+			return malformed(fmt.Sprintf("unknown critical header field %d", fieldID), start)
 		default:
 			// unknown non-critical field - safe to ignore
 			if fieldLen > maxFieldLength {
-				return errors.New("malformed")
+				return malformed("header field length", start)
 			}
 			_, err := readXBytes(in, int(fieldLen))
 			if err != nil {
-				return errors.New("malformed")
+				return malformed("header field", start)
 			}
 		}
 	}
@@ -195,7 +221,6 @@ type KeyEntry struct {
 }
 
 // Store implements writing a Key
-// TODO: FIXME: XXX: FINISH IMPLEMENT
 func (k KeyEntry) Store(out io.Writer) error {
 	err := writeBigEndianUint32(out, keyFieldVersion)
 	if err != nil {
@@ -219,6 +244,10 @@ func (k KeyEntry) Store(out io.Writer) error {
 	if err != nil {
 		return err
 	}
+	_, err = out.Write(k.AesKey)
+	if err != nil {
+		return err
+	}
 
 	// HMAC key
 	err = writeBigEndianUint32(out, keyFieldHmacKey)
@@ -229,6 +258,10 @@ func (k KeyEntry) Store(out io.Writer) error {
 	if err != nil {
 		return err
 	}
+	_, err = out.Write(k.HmacKey)
+	if err != nil {
+		return err
+	}
 
 	// End
 	err = writeBigEndianUint32(out, keyFieldEnd)
@@ -243,56 +276,82 @@ func (k *KeyEntry) Generate(version uint32) error {
 	return nil
 }
 
-// Load loads an entry from a stream
+// Lock locks the AES and HMAC keys into memory, so that they are never
+// written to swap. It is only supported on Linux, where it is limited by
+// RLIMIT_MEMLOCK; the entry is usable whether or not it succeeds.
+//
+// Locks are per page and do not nest, so the pages stay locked until the
+// process exits, even after Destroy; unlocking them early would also
+// unlock other keys sharing them.
+func (k *KeyEntry) Lock() error {
+	err := lockMemory(k.AesKey)
+	if err != nil {
+		return err
+	}
+	return lockMemory(k.HmacKey)
+}
+
+// Destroy wipes the AES and HMAC keys. Copies of the entry share them, so
+// none of them may be used afterwards.
+func (k *KeyEntry) Destroy() {
+	wipe(k.AesKey)
+	wipe(k.HmacKey)
+	k.AesKey = nil
+	k.HmacKey = nil
+}
+
+// Load loads an entry from a stream. Parse errors are an *ErrMalformedKey.
 func (k *KeyEntry) Load(in io.Reader) error {
+	r := newOffsetReader(in)
 	for {
-		fieldID, err := readBigEndianUint32(in)
+		start := r.offset
+		fieldID, err := readBigEndianUint32(r)
 		if err != nil {
-			return errors.New("malformed")
+			return malformed("entry field", start)
 		}
 		if fieldID == keyFieldEnd {
 			break
 		}
-		fieldLen, err := readBigEndianUint32(in)
+		fieldLen, err := readBigEndianUint32(r)
 		if err != nil {
-			return errors.New("malformed")
+			return malformed("entry field length", start)
 		}
 		switch fieldID {
 		case keyFieldVersion:
 			if fieldLen != 4 {
-				return fmt.Errorf("malformed version")
+				return malformed("version", start)
 			}
-			k.Version, err = readBigEndianUint32(in)
+			k.Version, err = readBigEndianUint32(r)
 			if err != nil {
-				return err
+				return malformed("version", start)
 			}
 		case keyFieldAesKey:
 			if fieldLen != aesKeyLen {
-				return fmt.Errorf("malformed (bad AES key)")
+				return malformed("AES key", start)
 			}
-			raw, err := readXBytes(in, int(fieldLen))
+			raw, err := readXBytes(r, int(fieldLen))
 			if err != nil {
-				return err
+				return malformed("AES key", start)
 			}
 			k.AesKey = raw
 		case keyFieldHmacKey:
 			if fieldLen != hmacKeyLen {
-				return fmt.Errorf("malformed (bad HMAC key)")
+				return malformed("HMAC key", start)
 			}
-			raw, err := readXBytes(in, int(fieldLen))
+			raw, err := readXBytes(r, int(fieldLen))
 			if err != nil {
-				return err
+				return malformed("HMAC key", start)
 			}
 			k.HmacKey = raw
 		case fieldID & 1:
-			return fmt.Errorf("malformed")
+			return malformed(fmt.Sprintf("unknown critical entry field %d", fieldID), start)
 		default:
 			if fieldLen > maxFieldLength {
-				return fmt.Errorf("malformed (> maxFieldLength)")
+				return malformed("entry field length", start)
 			}
-			_, err := readXBytes(in, int(fieldLen))
+			_, err := readXBytes(r, int(fieldLen))
 			if err != nil {
-				return err
+				return malformed("entry field", start)
 			}
 		}
 	}
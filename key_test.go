@@ -1,6 +1,7 @@
 package gitcrypt
 
 import (
+	"bytes"
 	"os"
 	"testing"
 )
@@ -18,3 +19,46 @@ func Test_Key(t *testing.T) {
 	}
 	t.Logf("%#v", k)
 }
+
+func Test_KeyDestroy(t *testing.T) {
+	key := testRepoKey(t, "")
+	copied := key
+	aesKey := key.Entries[0].AesKey
+	hmacKey := key.Entries[0].HmacKey
+
+	// Locking is best effort, limited by RLIMIT_MEMLOCK and the platform
+	err := key.Lock()
+	if err != nil {
+		t.Logf("Lock: %s", err.Error())
+	}
+	key.Destroy()
+	if key.Entries != nil {
+		t.Error("expected entries to be removed")
+	}
+	if !bytes.Equal(aesKey, make([]byte, len(aesKey))) || !bytes.Equal(hmacKey, make([]byte, len(hmacKey))) {
+		t.Error("expected key material to be wiped")
+	}
+	if copied.Entries[0].AesKey != nil {
+		t.Error("expected copies of the key to be destroyed too")
+	}
+	// Destroying twice is harmless
+	key.Destroy()
+}
+
+func Test_AesCtrEncryptorDestroy(t *testing.T) {
+	key := testRepoKey(t, "")
+	a := NewAesCtrEncryptor(key.Entries[0].AesKey, make([]byte, nonceLength))
+	in := []byte("plain text")
+	out := make([]byte, len(in))
+	err := a.process(in, out, uint32(len(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Destroy()
+	if !bytes.Equal(a.pad, make([]byte, len(a.pad))) || !bytes.Equal(a.ctrValue, make([]byte, len(a.ctrValue))) || a.byteCounter != 0 {
+		t.Error("expected pad, CTR value and counter to be wiped")
+	}
+	if len(key.Entries[0].AesKey) == 0 || bytes.Equal(key.Entries[0].AesKey, make([]byte, aesKeyLen)) {
+		t.Error("expected the caller's key to be left alone")
+	}
+}
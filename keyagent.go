@@ -0,0 +1,343 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The key agent caches unlocked repository keys in memory, so that
+// repeated decryptions (such as one filter process per file) do not have
+// to unwrap the repository key and prompt for passphrases every time. It
+// speaks a line based protocol over a Unix socket which is only accessible
+// to the user running it. Fields are URL query escaped:
+//
+//	PUT <repo> <key name> <ttl seconds> <base64 key file>  -> OK
+//	GET <repo> <key name>                                  -> KEY <base64 key file>
+//	FORGET <repo> <key name>                               -> OK
+//	FORGETALL                                              -> OK
+//
+// Failures are answered with ERR <message>.
+
+// DefaultKeyAgentTTL is how long the key agent holds keys which are added
+// without an explicit TTL.
+var DefaultKeyAgentTTL = 15 * time.Minute
+
+// ErrKeyAgentNoKey is returned by KeyAgentClient.Get when the agent does
+// not hold the requested key.
+var ErrKeyAgentNoKey = errors.New("key agent does not hold key")
+
+// KeyAgentSocketPath returns the default key agent socket path,
+// $GIT_CRYPT_AGENT_SOCK if it is set, otherwise agent.sock in a per-user
+// go-git-crypt directory under $XDG_RUNTIME_DIR or the temporary
+// directory.
+func KeyAgentSocketPath() string {
+	if p := os.Getenv("GIT_CRYPT_AGENT_SOCK"); p != "" {
+		return p
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "go-git-crypt", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("go-git-crypt-%d", os.Getuid()), "agent.sock")
+}
+
+type keyAgentEntry struct {
+	key     []byte
+	expires time.Time
+}
+
+// KeyAgent holds unlocked repository keys in memory for a limited time.
+type KeyAgent struct {
+	// TTL is the time keys are held when added without an explicit TTL.
+	// Defaults to DefaultKeyAgentTTL.
+	TTL time.Duration
+	// Logger optionally receives diagnostics, such as rejected
+	// connections. Keys are never logged.
+	Logger *slog.Logger
+
+	mu   sync.Mutex
+	keys map[string]keyAgentEntry
+	now  func() time.Time
+}
+
+// NewKeyAgent creates a KeyAgent holding keys for ttl by default.
+func NewKeyAgent(ttl time.Duration) *KeyAgent {
+	return &KeyAgent{TTL: ttl}
+}
+
+// ListenKeyAgent listens on a Unix socket for a KeyAgent. The directory
+// holding the socket is created if needed, and must be owned by the
+// current user and inaccessible to anyone else. A stale socket left by a
+// previous agent is replaced.
+func ListenKeyAgent(socketPath string) (net.Listener, error) {
+	dir := filepath.Dir(socketPath)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = checkKeyAgentDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if c, err := net.Dial("unix", socketPath); err == nil {
+		c.Close()
+		return nil, errors.New("key agent already running on " + socketPath)
+	}
+	os.Remove(socketPath)
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve answers key agent requests on l until it is closed, then wipes
+// the keys it holds.
+func (a *KeyAgent) Serve(l net.Listener) error {
+	defer func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for id := range a.keys {
+			a.forget(id)
+		}
+	}()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.serveConn(c)
+	}
+}
+
+func (a *KeyAgent) serveConn(c net.Conn) {
+	defer c.Close()
+	uid, err := keyAgentPeerUID(c)
+	if err != nil || uid != os.Getuid() {
+		a.logger().Warn("key agent: rejecting connection", "uid", uid)
+		return
+	}
+
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		reply, err := a.handle(strings.Fields(line))
+		if err != nil {
+			reply = "ERR " + err.Error()
+		}
+		_, err = c.Write([]byte(reply + "\n"))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (a *KeyAgent) handle(fields []string) (string, error) {
+	if len(fields) == 0 {
+		return "", errors.New("empty request")
+	}
+	args := make([]string, len(fields)-1)
+	for i, f := range fields[1:] {
+		v, err := url.QueryUnescape(f)
+		if err != nil {
+			return "", errors.New("malformed request")
+		}
+		args[i] = v
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	switch {
+	case fields[0] == "PUT" && len(args) == 4:
+		ttl, err := strconv.Atoi(args[2])
+		if err != nil || ttl < 0 {
+			return "", errors.New("malformed ttl")
+		}
+		raw, err := base64.StdEncoding.DecodeString(args[3])
+		if err != nil {
+			return "", errors.New("malformed key")
+		}
+		d := time.Duration(ttl) * time.Second
+		if d == 0 {
+			d = a.TTL
+		}
+		if d == 0 {
+			d = DefaultKeyAgentTTL
+		}
+		a.forget(keyAgentID(args[0], args[1]))
+		a.keys[keyAgentID(args[0], args[1])] = keyAgentEntry{key: raw, expires: a.clock().Add(d)}
+		return "OK", nil
+	case fields[0] == "GET" && len(args) == 2:
+		entry, ok := a.keys[keyAgentID(args[0], args[1])]
+		if !ok {
+			return "", errors.New("no such key")
+		}
+		return "KEY " + base64.StdEncoding.EncodeToString(entry.key), nil
+	case fields[0] == "FORGET" && len(args) == 2:
+		a.forget(keyAgentID(args[0], args[1]))
+		return "OK", nil
+	case fields[0] == "FORGETALL" && len(args) == 0:
+		for id := range a.keys {
+			a.forget(id)
+		}
+		return "OK", nil
+	}
+	return "", errors.New("unknown request")
+}
+
+// expire drops keys whose TTL has passed. It must be called with mu held.
+func (a *KeyAgent) expire() {
+	if a.keys == nil {
+		a.keys = make(map[string]keyAgentEntry)
+	}
+	now := a.clock()
+	for id, entry := range a.keys {
+		if !now.Before(entry.expires) {
+			a.forget(id)
+		}
+	}
+}
+
+// forget wipes and drops a key. It must be called with mu held.
+func (a *KeyAgent) forget(id string) {
+	if entry, ok := a.keys[id]; ok {
+		wipe(entry.key)
+		delete(a.keys, id)
+	}
+}
+
+func (a *KeyAgent) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+func keyAgentID(repo string, keyName string) string {
+	if keyName == "" {
+		keyName = "default"
+	}
+	return filepath.Clean(repo) + "\x00" + keyName
+}
+
+// KeyAgentClient talks to a running KeyAgent.
+type KeyAgentClient struct {
+	// SocketPath is the key agent socket. Defaults to KeyAgentSocketPath().
+	SocketPath string
+}
+
+// NewKeyAgentClient creates a KeyAgentClient for the agent on socketPath,
+// or the default socket if it is empty.
+func NewKeyAgentClient(socketPath string) *KeyAgentClient {
+	return &KeyAgentClient{SocketPath: socketPath}
+}
+
+// Get returns the key held by the agent for a repository and key name, or
+// ErrKeyAgentNoKey.
+func (k *KeyAgentClient) Get(repoPath string, keyName string) (Key, error) {
+	reply, err := k.request("GET", repoPath, keyName)
+	if err != nil {
+		return Key{}, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(reply, "KEY "))
+	if err != nil || !strings.HasPrefix(reply, "KEY ") {
+		return Key{}, errors.New("malformed key agent reply")
+	}
+	var key Key
+	err = key.Load(bytes.NewReader(raw))
+	wipe(raw)
+	if err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// Put hands an unlocked key for a repository to the agent, to be held for
+// ttl, or the agent's default TTL if ttl is zero.
+func (k *KeyAgentClient) Put(repoPath string, key Key, ttl time.Duration) error {
+	var buf bytes.Buffer
+	defer func() { wipe(buf.Bytes()) }()
+	err := key.Store(&buf)
+	if err != nil {
+		return err
+	}
+	_, err = k.request("PUT", repoPath, key.KeyName, strconv.Itoa(int(ttl/time.Second)), base64.StdEncoding.EncodeToString(buf.Bytes()))
+	return err
+}
+
+// Forget removes a repository key from the agent.
+func (k *KeyAgentClient) Forget(repoPath string, keyName string) error {
+	_, err := k.request("FORGET", repoPath, keyName)
+	return err
+}
+
+// ForgetAll removes every key from the agent.
+func (k *KeyAgentClient) ForgetAll() error {
+	_, err := k.request("FORGETALL")
+	return err
+}
+
+func (k *KeyAgentClient) request(cmd string, args ...string) (string, error) {
+	socketPath := k.SocketPath
+	if socketPath == "" {
+		socketPath = KeyAgentSocketPath()
+	}
+	// Refuse to hand keys to (or take them from) a socket someone else
+	// could have planted
+	err := checkKeyAgentDir(filepath.Dir(socketPath))
+	if err != nil {
+		return "", err
+	}
+	c, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	line := cmd
+	for _, arg := range args {
+		if arg == "" {
+			arg = "default"
+		}
+		line += " " + url.QueryEscape(arg)
+	}
+	_, err = c.Write([]byte(line + "\n"))
+	if err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSuffix(reply, "\n")
+	if reply == "ERR no such key" {
+		return "", ErrKeyAgentNoKey
+	}
+	if strings.HasPrefix(reply, "ERR ") {
+		return "", errors.New("key agent: " + strings.TrimPrefix(reply, "ERR "))
+	}
+	return reply, nil
+}
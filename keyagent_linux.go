@@ -0,0 +1,56 @@
+//go:build linux
+
+package gitcrypt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkKeyAgentDir verifies that the key agent socket directory is owned
+// by the current user and not accessible to anyone else.
+func checkKeyAgentDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok {
+		return fmt.Errorf("key agent directory %s is not a directory", dir)
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("key agent directory %s is not owned by the current user", dir)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("key agent directory %s is accessible by other users (mode %o)", dir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// keyAgentPeerUID returns the user id of the process on the other end of
+// a key agent connection.
+func keyAgentPeerUID(c net.Conn) (int, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return -1, errors.New("not a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+	return int(cred.Uid), nil
+}
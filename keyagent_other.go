@@ -0,0 +1,31 @@
+//go:build !linux
+
+package gitcrypt
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// checkKeyAgentDir verifies that the key agent socket directory is not
+// accessible to other users. Ownership is not checked on this platform.
+func checkKeyAgentDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("key agent directory %s is not a directory", dir)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("key agent directory %s is accessible by other users (mode %o)", dir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// keyAgentPeerUID cannot determine peer credentials on this platform, and
+// relies on the socket directory permissions instead.
+func keyAgentPeerUID(c net.Conn) (int, error) {
+	return os.Getuid(), nil
+}
@@ -0,0 +1,128 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_KeyAgent(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	l, err := ListenKeyAgent(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	now := time.Now()
+	a := NewKeyAgent(time.Minute)
+	a.now = func() time.Time { return now }
+	go a.Serve(l)
+
+	c := NewKeyAgentClient(socketPath)
+	_, err = c.Get("/src/repo", "")
+	if !errors.Is(err, ErrKeyAgentNoKey) {
+		t.Fatalf("expected ErrKeyAgentNoKey, got %v", err)
+	}
+
+	key := testRepoKey(t, "")
+	err = c.Put("/src/repo", key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod := testRepoKey(t, "prod")
+	err = c.Put("/src/repo/", prod, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := c.Get("/src/repo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached.Entries[0].AesKey, key.Entries[0].AesKey) {
+		t.Error("cached key does not match original")
+	}
+	_, err = c.Get("/src/other", "")
+	if !errors.Is(err, ErrKeyAgentNoKey) {
+		t.Errorf("expected key to be held per repository, got %v", err)
+	}
+
+	// The default key expires after the agent TTL, prod after its own
+	now = now.Add(2 * time.Minute)
+	_, err = c.Get("/src/repo", "")
+	if !errors.Is(err, ErrKeyAgentNoKey) {
+		t.Errorf("expected key to expire, got %v", err)
+	}
+	cached, err = c.Get("/src/repo", "prod")
+	if err != nil || cached.KeyName != "prod" {
+		t.Fatalf("expected prod key to be held, got %v", err)
+	}
+
+	err = c.Forget("/src/repo", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get("/src/repo", "prod")
+	if !errors.Is(err, ErrKeyAgentNoKey) {
+		t.Errorf("expected forgotten key to be gone, got %v", err)
+	}
+}
+
+func Test_KeyAgentDecrypt(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	l, err := ListenKeyAgent(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go NewKeyAgent(time.Minute).Serve(l)
+
+	// A rotated key, with files still encrypted under the older version
+	key := testRepoKey(t, "")
+	rotated := KeyEntry{}
+	if err = rotated.Generate(1); err != nil {
+		t.Fatal(err)
+	}
+	key.Entries = append(key.Entries, rotated)
+	c := NewKeyAgentClient(socketPath)
+	if err = c.Put("/src/repo", key, 0); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := c.Get("/src/repo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := GitCrypt{}
+	for _, entry := range key.Entries {
+		enc, err := encryptBlob(entry, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = g.DecryptStream(cached, enc[:gitCryptHeaderLen], bytes.NewReader(enc), &out)
+		if err != nil || out.String() != "secret" {
+			t.Errorf("version %d: unexpected output %q, %v", entry.Version, out.String(), err)
+		}
+	}
+}
+
+func Test_KeyAgentPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "agent")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ListenKeyAgent(filepath.Join(dir, "agent.sock"))
+	if err == nil {
+		t.Fatal("expected world readable socket directory to be refused")
+	}
+	_, err = NewKeyAgentClient(filepath.Join(dir, "agent.sock")).Get("/src/repo", "")
+	if err == nil || errors.Is(err, ErrKeyAgentNoKey) {
+		t.Errorf("expected client to refuse world readable socket directory, got %v", err)
+	}
+}
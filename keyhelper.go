@@ -0,0 +1,100 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// A key helper is an external program which hands out unlocked repository
+// keys, in the same spirit as git credential helpers. It is run through the
+// shell with "get" appended, and receives a request on stdin as key=value
+// lines terminated by a blank line:
+//
+//	repo=/path/to/repository
+//	key=default
+//	version=0
+//
+// It answers on stdout with the base64 encoded key file,
+//
+//	key=<base64 key file>
+//
+// or with error=<message> if it cannot supply the key. Unknown lines are
+// ignored in both directions.
+
+// keyHelperConfig is the git configuration key holding the key helper.
+const keyHelperConfig = "gitcrypt.keyhelper"
+
+// KeyFromHelper obtains an unlocked repository key for a key name and
+// version from the configured key helper. An empty keyName selects the
+// default key.
+func (g *GitCrypt) KeyFromHelper(repoPath string, keyName string, keyVersion uint32) (Key, error) {
+	helper := g.KeyHelper
+	if helper == "" {
+		var err error
+		helper, err = gitConfigGet(repoPath, keyHelperConfig)
+		if err != nil {
+			return Key{}, err
+		}
+	}
+	if helper == "" {
+		return Key{}, errors.New("no key helper configured")
+	}
+
+	name := keyName
+	if name == "" {
+		name = "default"
+	}
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "repo=%s\nkey=%s\nversion=%d\n\n", repoPath, name, keyVersion)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", helper+" get")
+	cmd.Dir = repoPath
+	cmd.Stdin = &request
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return Key{}, fmt.Errorf("key helper failed: %s: %s", err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	var encoded string
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for scanner.Scan() {
+		k, v, _ := strings.Cut(scanner.Text(), "=")
+		switch k {
+		case "key":
+			encoded = v
+		case "error":
+			return Key{}, errors.New("key helper: " + v)
+		}
+	}
+	if encoded == "" {
+		return Key{}, errors.New("key helper returned no key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Key{}, errors.New("key helper returned a malformed key")
+	}
+
+	var key Key
+	err = key.Load(bytes.NewReader(raw))
+	wipe(raw)
+	if err != nil {
+		return Key{}, fmt.Errorf("key helper returned a malformed key: %w", err)
+	}
+	if key.KeyName != keyName {
+		return Key{}, fmt.Errorf("key helper returned a key with the wrong name: %w", ErrKeyNameMismatch)
+	}
+	if _, err := key.Get(keyVersion); err != nil {
+		return Key{}, errors.New("key helper returned a key without version " + strconv.FormatUint(uint64(keyVersion), 10))
+	}
+	return key, nil
+}
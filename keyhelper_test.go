@@ -0,0 +1,94 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestKeyHelperProcess is not a real test; it is run as the key helper by
+// Test_KeyFromHelper, answering with the key file in
+// $GITCRYPT_TEST_KEYFILE.
+func TestKeyHelperProcess(t *testing.T) {
+	if os.Getenv("GITCRYPT_TEST_KEYFILE") == "" {
+		return
+	}
+	defer os.Exit(0)
+
+	request := map[string]string{}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() && scanner.Text() != "" {
+		k, v, _ := strings.Cut(scanner.Text(), "=")
+		request[k] = v
+	}
+	if os.Args[len(os.Args)-1] != "get" || request["key"] != "default" || request["version"] != "0" {
+		fmt.Printf("error=unexpected request %v\n", request)
+		return
+	}
+	raw, err := os.ReadFile(os.Getenv("GITCRYPT_TEST_KEYFILE"))
+	if err != nil {
+		fmt.Printf("error=%s\n", err.Error())
+		return
+	}
+	fmt.Printf("key=%s\n", base64.StdEncoding.EncodeToString(raw))
+}
+
+func Test_KeyFromHelper(t *testing.T) {
+	dir := t.TempDir()
+	key := testRepoKey(t, "")
+	var buf bytes.Buffer
+	err := key.Store(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key")
+	err = os.WriteFile(keyFile, buf.Bytes(), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITCRYPT_TEST_KEYFILE", keyFile)
+
+	repo := filepath.Join(dir, "repo")
+	for _, args := range [][]string{
+		{"init", "-q", repo},
+		{"-C", repo, "config", keyHelperConfig, os.Args[0] + " -test.run=^TestKeyHelperProcess$ --"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+
+	g := GitCrypt{}
+	unlocked, err := g.KeyFromHelper(repo, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unlocked.Entries[0].AesKey, key.Entries[0].AesKey) {
+		t.Error("key from helper does not match original")
+	}
+	enc := testEncrypt(t, key, "secret")
+	var out bytes.Buffer
+	err = g.DecryptStream(unlocked, enc[:gitCryptHeaderLen], bytes.NewReader(enc), &out)
+	if err != nil || out.String() != "secret" {
+		t.Errorf("key from helper does not decrypt: %q, %v", out.String(), err)
+	}
+
+	// Helper errors are reported
+	_, err = g.KeyFromHelper(repo, "", 1)
+	if err == nil || !strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("expected helper error, got %v", err)
+	}
+
+	// An explicit helper overrides the git configuration
+	g.KeyHelper = "false"
+	_, err = g.KeyFromHelper(repo, "", 0)
+	if err == nil {
+		t.Error("expected failing helper to be reported")
+	}
+}
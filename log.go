@@ -0,0 +1,64 @@
+package gitcrypt
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// discardHandler is a slog.Handler which drops everything, so the library
+// is silent unless given a logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+// debugLogger returns a logger writing everything to standard error, for
+// the Debug flags.
+func debugLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// logger returns the logger to use: Logger if set, a debug logger if Debug
+// is set, and otherwise one which discards everything.
+func (g *GitCrypt) logger() *slog.Logger {
+	switch {
+	case g == nil:
+		return discardLogger
+	case g.Logger != nil:
+		return g.Logger
+	case g.Debug:
+		return debugLogger()
+	}
+	return discardLogger
+}
+
+// logger returns the logger of the GitCrypt the key belongs to, or a debug
+// logger if Debug is set.
+func (k Key) logger() *slog.Logger {
+	if k.Parent != nil && k.Parent.Logger != nil {
+		return k.Parent.Logger
+	}
+	if k.Debug {
+		return debugLogger()
+	}
+	return k.Parent.logger()
+}
+
+func (o *OpenPGPWrapper) logger() *slog.Logger {
+	if o.Logger == nil {
+		return discardLogger
+	}
+	return o.Logger
+}
+
+func (a *KeyAgent) logger() *slog.Logger {
+	if a.Logger == nil {
+		return discardLogger
+	}
+	return a.Logger
+}
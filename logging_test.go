@@ -0,0 +1,145 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+// testLogSecrets returns encodings of every 8 byte window of secret, any of
+// which appearing in log output would leak part of it: hex, base64, and
+// the forms fmt and slog print byte slices in.
+func testLogSecrets(secret []byte) []string {
+	out := make([]string, 0)
+	for i := 0; i+8 <= len(secret); i++ {
+		w := secret[i : i+8]
+		out = append(out,
+			hex.EncodeToString(w),
+			strings.ToUpper(hex.EncodeToString(w)),
+			base64.RawStdEncoding.EncodeToString(w[:6]),
+			strings.Trim(fmt.Sprint(w), "[]"),
+			strings.Trim(fmt.Sprintf("%#v", w), "[]byte{}"),
+			strings.Trim(strconv.Quote(string(w)), "\""))
+	}
+	return out
+}
+
+func Test_LoggingNoSecrets(t *testing.T) {
+	var std bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&std)
+
+	var logged bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.Level(-100)}))
+	gpg.SetLogger(logger)
+	defer gpg.SetLogger(nil)
+	g := GitCrypt{Debug: true, Logger: logger}
+
+	const plain = "PLAINTEXT-MARKER database password hunter2"
+	key := testRepoKey(t, "")
+	key.Parent = &g
+	alice := testEntity(t, "alice")
+	bob := testEntity(t, "bob")
+
+	// Wrapping and unwrapping, successfully and not
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+	w := g.openPGPWrapper(openpgp.EntityList{alice})
+	_, err := g.WrapRepoKey(w, key, "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := g.UnwrapRepoKey(w, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.UnwrapRepoKey(g.openPGPWrapper(openpgp.EntityList{bob}), "", 0, nil, keysPath)
+	if err == nil {
+		t.Error("expected unwrap with a different key to fail")
+	}
+
+	// Storing and loading keys
+	var stored bytes.Buffer
+	err = unwrapped.Store(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := Key{Parent: &g}
+	err = loaded.Load(bytes.NewReader(stored.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decrypting files
+	enc := testEncrypt(t, key, plain)
+	path := filepath.Join(t.TempDir(), "secret")
+	err = os.WriteFile(path, enc, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.IsGitCrypted(path) {
+		t.Error("expected file to be git-crypted")
+	}
+	var out bytes.Buffer
+	err = g.DecryptStream(key, enc[:gitCryptHeaderLen], bytes.NewReader(enc), &out)
+	if err != nil || out.String() != plain {
+		t.Fatalf("decryption failed: %v", err)
+	}
+	tampered := append([]byte{}, enc...)
+	tampered[len(tampered)-1] ^= 1
+	err = g.DecryptStream(key, tampered[:gitCryptHeaderLen], bytes.NewReader(tampered), &out)
+	if err == nil {
+		t.Error("expected tampered file to fail")
+	}
+	out.Reset()
+	err = g.TextConv([]Key{key}, bytes.NewReader(enc), &out, nil)
+	if err != nil || out.String() != plain {
+		t.Fatalf("textconv failed: %v", err)
+	}
+	repo := testGitRepo(t, map[string][]byte{
+		".gitattributes": []byte("secret filter=git-crypt\n"),
+		"secret":         enc,
+	}, true)
+	_, err = g.Verify(repo, []Key{key}, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if std.Len() != 0 {
+		t.Errorf("unexpected output to the standard logger:\n%s", std.String())
+	}
+	output := logged.String()
+	if output == "" {
+		t.Fatal("expected debug output")
+	}
+	secrets := []string{"PLAINTEXT-MARKER", "hunter2", "PRIVATE KEY"}
+	secrets = append(secrets, testLogSecrets([]byte(plain))...)
+	secrets = append(secrets, testLogSecrets(key.Entries[0].AesKey)...)
+	secrets = append(secrets, testLogSecrets(key.Entries[0].HmacKey)...)
+	for _, s := range secrets {
+		if strings.Contains(output, s) {
+			t.Errorf("log output contains %q:\n%s", s, output)
+		}
+	}
+}
+
+func Test_LoggingSilentByDefault(t *testing.T) {
+	g := GitCrypt{}
+	if g.logger().Enabled(context.Background(), slog.LevelError) {
+		t.Error("expected the default logger to discard everything")
+	}
+	if (Key{}).logger().Enabled(context.Background(), slog.LevelError) {
+		t.Error("expected the default key logger to discard everything")
+	}
+}
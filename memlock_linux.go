@@ -0,0 +1,15 @@
+//go:build linux
+
+package gitcrypt
+
+import "syscall"
+
+// lockMemory locks the pages holding b into memory, so they are never
+// written to swap. They are deliberately never unlocked, as other keys may
+// share them.
+func lockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Mlock(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package gitcrypt
+
+import "errors"
+
+// lockMemory is not supported on this platform.
+func lockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return errors.ErrUnsupported
+}
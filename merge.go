@@ -0,0 +1,135 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// MergeOptions configures Merge.
+type MergeOptions struct {
+	// MarkerSize is the length of conflict markers, 7 if zero.
+	MarkerSize int
+	// OursLabel, BaseLabel and TheirsLabel label the sides of conflicts.
+	OursLabel   string
+	BaseLabel   string
+	TheirsLabel string
+}
+
+// KeyNameForPath returns the name of the key a file is encrypted with,
+// according to .gitattributes in the working tree, reporting whether the
+// file is encrypted at all.
+func (g *GitCrypt) KeyNameForPath(repoPath string, name string) (string, bool, error) {
+	attrs, err := gitFilterAttributes(repoPath, nil, []string{name}, false)
+	if err != nil {
+		return "", false, err
+	}
+	keyName, ok := filterKeyName(attrs[name])
+	return keyName, ok, nil
+}
+
+// Merge performs a three-way merge of git-crypted files, as a git merge
+// driver. The base, ours and theirs versions are decrypted with keys, merged
+// with "git merge-file", and the result encrypted with the latest version of
+// the key which decrypted ours, exactly as the clean filter would. Versions
+// which are not encrypted, such as an empty base, are merged as they are.
+// Conflict markers are written into the plain text, and conflicts is set if
+// there were any.
+//
+// The plain text is written to a private temporary directory for the
+// duration of the merge.
+func (g *GitCrypt) Merge(keys []Key, base, ours, theirs []byte, opts MergeOptions) ([]byte, bool, error) {
+	oursPlain, key, err := decryptWithKeys(keys, ours)
+	if err != nil {
+		return nil, false, fmt.Errorf("ours: %w", err)
+	}
+	basePlain, _, err := decryptWithKeys(keys, base)
+	if err != nil {
+		return nil, false, fmt.Errorf("base: %w", err)
+	}
+	theirsPlain, theirsKey, err := decryptWithKeys(keys, theirs)
+	if err != nil {
+		return nil, false, fmt.Errorf("theirs: %w", err)
+	}
+	if key == nil {
+		key = theirsKey
+	}
+	if key == nil {
+		return nil, false, errors.New("neither side of the merge is encrypted")
+	}
+	entry, err := key.Latest()
+	if err != nil {
+		return nil, false, err
+	}
+
+	tmp, err := os.MkdirTemp("", "go-git-crypt-merge")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.RemoveAll(tmp)
+	files := make([]string, 3)
+	for i, data := range [][]byte{oursPlain, basePlain, theirsPlain} {
+		files[i] = filepath.Join(tmp, strconv.Itoa(i))
+		err = os.WriteFile(files[i], data, 0600)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	markerSize := opts.MarkerSize
+	if markerSize == 0 {
+		markerSize = 7
+	}
+	labels := []string{opts.OursLabel, opts.BaseLabel, opts.TheirsLabel}
+	defaults := []string{"ours", "base", "theirs"}
+	args := []string{"merge-file", "-p", "--marker-size=" + strconv.Itoa(markerSize)}
+	for i, label := range labels {
+		if label == "" {
+			label = defaults[i]
+		}
+		args = append(args, "-L", label)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append(args, files...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	conflicts := false
+	if err != nil {
+		// merge-file exits with the number of conflicts, or a negative
+		// status on errors
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() > 127 {
+			return nil, false, fmt.Errorf("git merge-file: %s: %s", err.Error(), bytes.TrimSpace(stderr.Bytes()))
+		}
+		conflicts = true
+	}
+
+	merged, err := encryptBlob(entry, stdout.Bytes())
+	if err != nil {
+		return nil, false, err
+	}
+	return merged, conflicts, nil
+}
+
+// decryptWithKeys decrypts a git-crypted file with whichever of the keys
+// matches, returning that key. Files which are not encrypted are returned
+// as they are, with a nil key.
+func decryptWithKeys(keys []Key, data []byte) ([]byte, *Key, error) {
+	if !bytes.HasPrefix(data, gitCryptHeader) {
+		return data, nil, nil
+	}
+	for i := range keys {
+		for _, entry := range keys[i].Entries {
+			plain, err := decryptBlob(entry, data)
+			if err == nil {
+				return plain, &keys[i], nil
+			}
+		}
+	}
+	return nil, nil, ErrNoMatchingKey
+}
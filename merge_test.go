@@ -0,0 +1,53 @@
+package gitcrypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Merge(t *testing.T) {
+	key := testRepoKey(t, "")
+	other := testRepoKey(t, "")
+	g := GitCrypt{}
+
+	base := testEncrypt(t, key, "a=1\nb=2\nc=3\n")
+	ours := testEncrypt(t, key, "a=10\nb=2\nc=3\n")
+	theirs := testEncrypt(t, key, "a=1\nb=2\nc=30\n")
+	merged, conflicts, err := g.Merge([]Key{other, key}, base, ours, theirs, MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conflicts {
+		t.Error("unexpected conflicts")
+	}
+	// The result is encrypted exactly as the clean filter would
+	if string(merged) != string(testEncrypt(t, key, "a=10\nb=2\nc=30\n")) {
+		t.Error("unexpected merge result")
+	}
+
+	theirs = testEncrypt(t, key, "a=100\nb=2\nc=3\n")
+	merged, conflicts, err = g.Merge([]Key{key}, base, ours, theirs, MergeOptions{MarkerSize: 3, OursLabel: "HEAD", TheirsLabel: "feature"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conflicts {
+		t.Error("expected conflicts")
+	}
+	plain, err := decryptBlob(key.Entries[0], merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(plain), "<<< HEAD\na=10\n===\na=100\n>>> feature\n") {
+		t.Errorf("unexpected conflict markers %q", plain)
+	}
+
+	// Both sides added the file
+	merged, conflicts, err = g.Merge([]Key{key}, nil, ours, ours, MergeOptions{})
+	if err != nil || conflicts || string(merged) != string(ours) {
+		t.Errorf("unexpected result %v, %v", conflicts, err)
+	}
+
+	if _, _, err = g.Merge([]Key{other}, base, ours, theirs, MergeOptions{}); err == nil {
+		t.Error("merged without a matching key")
+	}
+}
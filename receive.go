@@ -0,0 +1,202 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ReceiveUpdate is a ref update, as given to a pre-receive hook.
+type ReceiveUpdate struct {
+	Old string
+	New string
+	Ref string
+}
+
+// ReceivePolicy configures CheckReceive.
+type ReceivePolicy struct {
+	// AllowPaths are path patterns, as for path.Match, which may be pushed
+	// in plain text. Patterns without a slash also match the base name.
+	AllowPaths []string
+	// AllowRefs are ref patterns, such as "refs/heads/scratch/*", which are
+	// not checked.
+	AllowRefs []string
+	// AllowRecipientReplacement permits pushes which remove every existing
+	// recipient of a key version.
+	AllowRecipientReplacement bool
+}
+
+// ReceiveViolation is a reason to reject a push.
+type ReceiveViolation struct {
+	Ref    string
+	Commit string
+	Path   string
+	Reason string
+}
+
+// Git configuration keys read by ReceivePolicyFromConfig.
+const (
+	receiveAllowPathConfig        = "gitcrypt.receive.allowPath"
+	receiveAllowRefConfig         = "gitcrypt.receive.allowRef"
+	receiveAllowReplacementConfig = "gitcrypt.receive.allowRecipientReplacement"
+)
+
+// ReadReceiveUpdates reads the "<old> <new> <ref>" lines given to a
+// pre-receive hook on standard input.
+func ReadReceiveUpdates(in io.Reader) ([]ReceiveUpdate, error) {
+	updates := make([]ReceiveUpdate, 0)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return updates, fmt.Errorf("malformed ref update %q", scanner.Text())
+		}
+		updates = append(updates, ReceiveUpdate{Old: fields[0], New: fields[1], Ref: fields[2]})
+	}
+	return updates, scanner.Err()
+}
+
+// ReceivePolicyFromConfig reads a ReceivePolicy from the git configuration
+// of a repository: the multi-valued gitcrypt.receive.allowPath and
+// gitcrypt.receive.allowRef, and gitcrypt.receive.allowRecipientReplacement.
+func (g *GitCrypt) ReceivePolicyFromConfig(repoPath string) (ReceivePolicy, error) {
+	var policy ReceivePolicy
+	var err error
+	policy.AllowPaths, err = gitConfigGetAll(repoPath, receiveAllowPathConfig)
+	if err != nil {
+		return policy, err
+	}
+	policy.AllowRefs, err = gitConfigGetAll(repoPath, receiveAllowRefConfig)
+	if err != nil {
+		return policy, err
+	}
+	out, err := gitCommand(repoPath, nil, "config", "--type=bool", "--default=false", receiveAllowReplacementConfig)
+	if err != nil {
+		return policy, err
+	}
+	policy.AllowRecipientReplacement = strings.TrimSpace(string(out)) == "true"
+	return policy, nil
+}
+
+// CheckReceive checks pushed ref updates before they are accepted, as a
+// pre-receive hook, typically in a bare repository. It reports every blob
+// in a pushed commit which .gitattributes at that commit marks for
+// encryption but which is in plain text, and every update of an existing
+// ref which removes all the recipients of a key version in
+// .git-crypt/keys, so that nobody who could read the files before can read
+// them afterwards. Ref deletions are not checked.
+func (g *GitCrypt) CheckReceive(repoPath string, updates []ReceiveUpdate, policy ReceivePolicy) ([]ReceiveViolation, error) {
+	violations := make([]ReceiveViolation, 0)
+	scanned := make(map[string]bool)
+	for _, u := range updates {
+		if isZeroObjectID(u.New) || matchAnyRef(policy.AllowRefs, u.Ref) {
+			continue
+		}
+
+		// Pushed commits are those not reachable from any existing ref
+		commits, err := gitLogCommits(repoPath, u.New, "--not", "--all")
+		if err != nil {
+			return violations, err
+		}
+		unscanned := make([]historyCommit, 0)
+		for _, c := range commits {
+			if !scanned[c.ID] {
+				scanned[c.ID] = true
+				unscanned = append(unscanned, c)
+			}
+		}
+		leaks, err := g.scanCommits(repoPath, unscanned)
+		if err != nil {
+			return violations, err
+		}
+		for _, l := range leaks {
+			if matchAnyPath(policy.AllowPaths, l.Path) {
+				continue
+			}
+			violations = append(violations, ReceiveViolation{
+				Ref:    u.Ref,
+				Commit: l.Commit,
+				Path:   l.Path,
+				Reason: "encrypted path pushed in plain text",
+			})
+		}
+
+		if isZeroObjectID(u.Old) || policy.AllowRecipientReplacement {
+			continue
+		}
+		before, err := keyRecipients(repoPath, u.Old)
+		if err != nil {
+			return violations, err
+		}
+		after, err := keyRecipients(repoPath, u.New)
+		if err != nil {
+			return violations, err
+		}
+		dirs := make([]string, 0, len(before))
+		for dir := range before {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			kept := false
+			for recipient := range before[dir] {
+				if after[dir][recipient] {
+					kept = true
+					break
+				}
+			}
+			if !kept {
+				violations = append(violations, ReceiveViolation{
+					Ref:    u.Ref,
+					Commit: u.New,
+					Path:   dir,
+					Reason: "removes every existing recipient of the key",
+				})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// keyRecipients returns the wrapped key files in .git-crypt/keys of a
+// commit, by key version directory.
+func keyRecipients(repoPath string, commit string) (map[string]map[string]bool, error) {
+	out, err := gitCommand(repoPath, nil, "ls-tree", "-r", "-z", "--name-only", commit, "--", ".git-crypt/keys")
+	if err != nil {
+		return nil, err
+	}
+	recipients := make(map[string]map[string]bool)
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p == "" {
+			continue
+		}
+		dir := path.Dir(p)
+		if recipients[dir] == nil {
+			recipients[dir] = make(map[string]bool)
+		}
+		recipients[dir][path.Base(p)] = true
+	}
+	return recipients, nil
+}
+
+// isZeroObjectID reports whether an object id is the all zero id git uses
+// for refs being created or deleted.
+func isZeroObjectID(id string) bool {
+	return strings.Trim(id, "0") == ""
+}
+
+// matchAnyRef reports whether a ref matches any of the patterns.
+func matchAnyRef(patterns []string, ref string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
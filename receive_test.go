@@ -0,0 +1,88 @@
+package gitcrypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_CheckReceive(t *testing.T) {
+	key := testRepoKey(t, "")
+	work := testGitRepo(t, map[string][]byte{
+		".gitattributes":                  []byte("*.env filter=git-crypt\n"),
+		"README":                          []byte("hello"),
+		"prod.env":                        testEncrypt(t, key, "TOKEN=abc"),
+		".git-crypt/keys/default/0/A.gpg": []byte("alice"),
+		".git-crypt/keys/default/0/B.gpg": []byte("bob"),
+	}, true)
+	bare := t.TempDir()
+	testGit(t, bare, "init", "-q", "--bare")
+	testGit(t, work, "push", "-q", bare, "HEAD:refs/heads/main")
+	old := strings.TrimSpace(testGit(t, work, "rev-parse", "HEAD"))
+
+	// Replace the recipients and leak a secret, in separate commits
+	testGit(t, work, "rm", "-q", ".git-crypt/keys/default/0/A.gpg", ".git-crypt/keys/default/0/B.gpg")
+	testWriteFiles(t, work, map[string][]byte{
+		".git-crypt/keys/default/0/M.gpg": []byte("mallory"),
+	})
+	testGit(t, work, "add", ".")
+	testGit(t, work, "commit", "-q", "-m", "replace recipients")
+	testWriteFiles(t, work, map[string][]byte{
+		"dev.env": []byte("TOKEN=def"),
+	})
+	testGit(t, work, "add", ".")
+	testGit(t, work, "commit", "-q", "-m", "leak")
+	pushed := strings.TrimSpace(testGit(t, work, "rev-parse", "HEAD"))
+
+	// Fetching without updating any ref leaves the repository as a
+	// pre-receive hook sees it
+	testGit(t, bare, "fetch", "-q", work, "HEAD")
+	updates, err := ReadReceiveUpdates(strings.NewReader(old + " " + pushed + " refs/heads/main\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := GitCrypt{}
+	violations, err := g.CheckReceive(bare, updates, ReceivePolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, got %v", violations)
+	}
+	if violations[0].Path != "dev.env" || violations[0].Commit != pushed {
+		t.Errorf("unexpected violation %+v", violations[0])
+	}
+	if violations[1].Path != ".git-crypt/keys/default/0" {
+		t.Errorf("unexpected violation %+v", violations[1])
+	}
+
+	// The allow-list comes from the git configuration
+	testGit(t, bare, "config", "--add", "gitcrypt.receive.allowPath", "dev.*")
+	testGit(t, bare, "config", "gitcrypt.receive.allowRecipientReplacement", "true")
+	policy, err := g.ReceivePolicyFromConfig(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err = g.CheckReceive(bare, updates, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("expected no violations, got %v", violations)
+	}
+
+	violations, err = g.CheckReceive(bare, updates, ReceivePolicy{AllowRefs: []string{"refs/heads/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("expected no violations, got %v", violations)
+	}
+
+	// Deleting a ref is not checked
+	zero := strings.Repeat("0", len(old))
+	violations, err = g.CheckReceive(bare, []ReceiveUpdate{{Old: old, New: zero, Ref: "refs/heads/main"}}, ReceivePolicy{})
+	if err != nil || len(violations) != 0 {
+		t.Errorf("unexpected result %v, %v", violations, err)
+	}
+}
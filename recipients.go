@@ -0,0 +1,322 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+// The recipient policy file, .git-crypt/recipients, declares who should be
+// able to unlock each key, so that access can be managed in code review.
+// Sections name keys, and list one recipient per line, either as a GPG
+// fingerprint or as the path of a public key file relative to the
+// repository root, optionally followed by an expiry date:
+//
+//	# Recipients of the default key
+//	[default]
+//	0123456789ABCDEF0123456789ABCDEF01234567
+//	keys/alice.asc expires=2027-01-31
+//
+//	[infra]
+//	keys/ops.asc
+//
+// Lines before the first section belong to the default key.
+
+// RecipientPolicyFile is the path of the recipient policy file, relative to
+// the repository root.
+var RecipientPolicyFile = filepath.Join(".git-crypt", "recipients")
+
+var fingerprintPattern = regexp.MustCompile(`^[0-9A-Fa-f]{40}$`)
+
+// PolicyRecipient is a single recipient in a RecipientPolicy.
+type PolicyRecipient struct {
+	// Fingerprint is the recipient's GPG fingerprint, if given directly
+	Fingerprint string
+	// KeyFile is the path of the recipient's public key file, relative to
+	// the repository root, if given instead of a fingerprint
+	KeyFile string
+	// Expires is the date after which the recipient loses access. It is
+	// zero if access does not expire.
+	Expires time.Time
+	// Line is the line of the policy file declaring the recipient
+	Line int
+}
+
+// RecipientPolicy maps key names, with "" for the default key, to the
+// recipients which should be able to unlock them.
+type RecipientPolicy map[string][]PolicyRecipient
+
+// LoadRecipientPolicy parses a recipient policy file.
+func LoadRecipientPolicy(in io.Reader) (RecipientPolicy, error) {
+	policy := make(RecipientPolicy)
+	keyName := ""
+	lineNo := 0
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			keyName = strings.TrimSpace(line[1 : len(line)-1])
+			if keyName == "default" {
+				keyName = ""
+			} else if err := validateKeyName(keyName); err != nil {
+				return nil, fmt.Errorf("recipients:%d: invalid key name: %s", lineNo, err.Error())
+			}
+			if _, ok := policy[keyName]; !ok {
+				policy[keyName] = make([]PolicyRecipient, 0)
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		r := PolicyRecipient{Line: lineNo}
+		if fingerprintPattern.MatchString(fields[0]) {
+			r.Fingerprint = strings.ToUpper(fields[0])
+		} else {
+			r.KeyFile = fields[0]
+		}
+		for _, option := range fields[1:] {
+			k, v, _ := strings.Cut(option, "=")
+			switch k {
+			case "expires":
+				t, err := time.Parse("2006-01-02", v)
+				if err != nil {
+					return nil, fmt.Errorf("recipients:%d: invalid expiry date %q", lineNo, v)
+				}
+				// Access lasts until the end of the expiry day
+				r.Expires = t.Add(24 * time.Hour)
+			default:
+				return nil, fmt.Errorf("recipients:%d: unknown option %q", lineNo, option)
+			}
+		}
+		policy[keyName] = append(policy[keyName], r)
+	}
+	return policy, scanner.Err()
+}
+
+// ReadRecipientPolicy reads the recipient policy file of a repository.
+func (g *GitCrypt) ReadRecipientPolicy(repoPath string) (RecipientPolicy, error) {
+	data, err := g.readFile(filepath.Join(repoPath, RecipientPolicyFile))
+	if err != nil {
+		return nil, err
+	}
+	return LoadRecipientPolicy(strings.NewReader(string(data)))
+}
+
+// SyncPlan describes the changes needed to bring the wrapped GPG key files
+// of one key in line with the recipient policy.
+type SyncPlan struct {
+	KeyName string
+	// Version is the key version whose wrapped key files were compared
+	Version uint32
+	// Add holds the recipients missing a wrapped key file
+	Add openpgp.EntityList
+	// Remove holds the fingerprints of wrapped key files not in the policy
+	Remove []string
+	// Expired holds the fingerprints of recipients whose access expired
+	Expired []string
+}
+
+// Empty reports whether the plan has nothing to do.
+func (p SyncPlan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Remove) == 0
+}
+
+// PlanSync compares the recipient policy with the wrapped GPG key files of
+// the latest version of each key in it. Recipients given by fingerprint
+// are looked up in keyring, which is needed to wrap keys for them; key
+// files are read relative to repoPath.
+func (g *GitCrypt) PlanSync(policy RecipientPolicy, repoPath string, keyring openpgp.EntityList, now time.Time) ([]SyncPlan, error) {
+	keysPath := filepath.Join(repoPath, ".git-crypt", "keys")
+	names := make([]string, 0, len(policy))
+	for name := range policy {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	plans := make([]SyncPlan, 0, len(names))
+	for _, name := range names {
+		version, err := g.LatestKeyVersion(keysPath, name)
+		if err != nil {
+			return plans, err
+		}
+		plan := SyncPlan{KeyName: name, Version: version}
+
+		wanted := make(map[string]*openpgp.Entity)
+		for _, r := range policy[name] {
+			e, err := g.resolveRecipient(r, repoPath, keyring)
+			if err != nil {
+				return plans, err
+			}
+			fpr := gpg.Fingerprint(e)
+			if !r.Expires.IsZero() && !now.Before(r.Expires) {
+				plan.Expired = append(plan.Expired, fpr)
+				continue
+			}
+			wanted[fpr] = e
+		}
+
+		have := make(map[string]bool)
+		dir := keyDirectory(keysPath, name, version)
+		files, err := g.readDirNames(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return plans, err
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f, ".gpg") {
+				continue
+			}
+			fpr := strings.ToUpper(strings.TrimSuffix(f, ".gpg"))
+			have[fpr] = true
+			if _, ok := wanted[fpr]; !ok {
+				plan.Remove = append(plan.Remove, fpr)
+			}
+		}
+		for fpr, e := range wanted {
+			if !have[fpr] {
+				plan.Add = append(plan.Add, e)
+			}
+		}
+		sort.Slice(plan.Add, func(i, j int) bool {
+			return gpg.Fingerprint(plan.Add[i]) < gpg.Fingerprint(plan.Add[j])
+		})
+		sort.Strings(plan.Remove)
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// SyncRecipients applies a SyncPlan: it wraps the key for missing
+// recipients with w, which is usually an OpenPGPWrapper with a Signer, and
+// deletes the wrapped key files of removed recipients. key must be the
+// unlocked key the plan is for.
+//
+// If rotate is set and recipients are removed, a new key version is
+// generated instead and wrapped for every remaining recipient, so removed
+// recipients cannot read anything encrypted from then on. The wrapped key
+// files of older versions are left in place, since their holders could
+// already read what those versions protect. The updated key is returned.
+func (g *GitCrypt) SyncRecipients(plan SyncPlan, key Key, w *OpenPGPWrapper, repoPath string, keyring openpgp.EntityList, policy RecipientPolicy, rotate bool) (Key, error) {
+	keysPath := filepath.Join(repoPath, ".git-crypt", "keys")
+	if key.KeyName != plan.KeyName {
+		return key, errors.New("key does not match sync plan")
+	}
+
+	if rotate && len(plan.Remove) > 0 {
+		latest, err := key.Latest()
+		if err != nil {
+			return key, err
+		}
+		var entry KeyEntry
+		err = entry.Generate(latest.Version + 1)
+		if err != nil {
+			return key, err
+		}
+		key.Entries = append(key.Entries, entry)
+		g.logger().Info("rotated key", "key", keyDisplayName(plan.KeyName), "version", entry.Version)
+
+		// Everyone still in the policy gets the new version
+		now := time.Now()
+		for _, r := range policy[plan.KeyName] {
+			if !r.Expires.IsZero() && !now.Before(r.Expires) {
+				continue
+			}
+			e, err := g.resolveRecipient(r, repoPath, keyring)
+			if err != nil {
+				return key, err
+			}
+			err = g.wrapFor(w, key, e, keysPath)
+			if err != nil {
+				return key, err
+			}
+		}
+		return key, nil
+	}
+
+	for _, e := range plan.Add {
+		err := g.wrapFor(w, key, e, keysPath)
+		if err != nil {
+			return key, err
+		}
+	}
+	dir := keyDirectory(keysPath, plan.KeyName, plan.Version)
+	for _, fpr := range plan.Remove {
+		path := filepath.Join(dir, fpr+".gpg")
+		if _, err := os.Stat(path); err != nil {
+			// Fingerprints are compared case insensitively
+			path = filepath.Join(dir, strings.ToLower(fpr)+".gpg")
+		}
+		err := os.Remove(path)
+		if err != nil {
+			return key, err
+		}
+	}
+	return key, nil
+}
+
+// LatestKeyVersion returns the highest version of a key with wrapped key
+// files, or 0 if there are none yet.
+func (g *GitCrypt) LatestKeyVersion(keysPath string, keyName string) (uint32, error) {
+	dir := filepath.Dir(keyDirectory(keysPath, keyName, 0))
+	names, err := g.readDirNames(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	latest := uint32(0)
+	for _, name := range names {
+		v, err := strconv.ParseUint(name, 10, 32)
+		if err == nil && uint32(v) > latest {
+			latest = uint32(v)
+		}
+	}
+	return latest, nil
+}
+
+// wrapFor wraps key for a single recipient entity with the settings of w.
+func (g *GitCrypt) wrapFor(w *OpenPGPWrapper, key Key, e *openpgp.Entity, keysPath string) error {
+	rw := *w
+	rw.Keyring = openpgp.EntityList{e}
+	_, err := g.WrapRepoKey(&rw, key, "", keysPath)
+	return err
+}
+
+// resolveRecipient finds the public key for a policy recipient.
+func (g *GitCrypt) resolveRecipient(r PolicyRecipient, repoPath string, keyring openpgp.EntityList) (*openpgp.Entity, error) {
+	if r.KeyFile != "" {
+		data, err := g.readFile(filepath.Join(repoPath, filepath.FromSlash(r.KeyFile)))
+		if err != nil {
+			return nil, fmt.Errorf("recipients:%d: %s", r.Line, err.Error())
+		}
+		el, err := gpg.ReadKeyRing(strings.NewReader(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("recipients:%d: %s: %s", r.Line, r.KeyFile, err.Error())
+		}
+		if len(el) != 1 {
+			return nil, fmt.Errorf("recipients:%d: %s must hold exactly one key", r.Line, r.KeyFile)
+		}
+		return el[0], nil
+	}
+	for _, e := range keyring {
+		if gpg.Fingerprint(e) == r.Fingerprint {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("recipients:%d: no public key for %s in keyring", r.Line, r.Fingerprint)
+}
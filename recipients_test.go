@@ -0,0 +1,153 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+func Test_LoadRecipientPolicy(t *testing.T) {
+	policy, err := LoadRecipientPolicy(strings.NewReader(`
+# default key
+0123456789abcdef0123456789abcdef01234567
+[infra]
+keys/ops.asc expires=2027-01-31
+[default]
+keys/alice.asc
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy[""]) != 2 || policy[""][0].Fingerprint != "0123456789ABCDEF0123456789ABCDEF01234567" || policy[""][1].KeyFile != "keys/alice.asc" {
+		t.Errorf("unexpected default recipients %#v", policy[""])
+	}
+	ops := policy["infra"][0]
+	if ops.KeyFile != "keys/ops.asc" || ops.Expires.Format("2006-01-02") != "2027-02-01" || ops.Line != 5 {
+		t.Errorf("unexpected infra recipients %#v", policy["infra"])
+	}
+	for _, section := range []string{"[]", "[in fra]"} {
+		_, err = LoadRecipientPolicy(strings.NewReader(section + "\nkeys/ops.asc\n"))
+		if err == nil {
+			t.Errorf("expected section %s to be rejected", section)
+		}
+	}
+
+	_, err = LoadRecipientPolicy(strings.NewReader("keys/ops.asc until=tomorrow\n"))
+	if err == nil {
+		t.Error("expected unknown option to be rejected")
+	}
+}
+
+func wrappedRecipients(t *testing.T, repo string, version string) []string {
+	entries, err := os.ReadDir(filepath.Join(repo, ".git-crypt", "keys", "default", version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fprs := make([]string, 0)
+	for _, e := range entries {
+		fprs = append(fprs, strings.TrimSuffix(e.Name(), ".gpg"))
+	}
+	sort.Strings(fprs)
+	return fprs
+}
+
+func Test_SyncRecipients(t *testing.T) {
+	g := GitCrypt{}
+	repo := t.TempDir()
+	keysPath := filepath.Join(repo, ".git-crypt", "keys")
+	alice := testEntity(t, "alice")
+	bob := testEntity(t, "bob")
+	carol := testEntity(t, "carol")
+
+	// bob's public key is committed to the repository
+	err := os.MkdirAll(filepath.Join(repo, "keys"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub bytes.Buffer
+	aw, _ := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	bob.Serialize(aw)
+	aw.Close()
+	err = os.WriteFile(filepath.Join(repo, "keys", "bob.asc"), pub.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := testRepoKey(t, "")
+	for _, e := range []*openpgp.Entity{alice, carol} {
+		_, err = g.WrapRepoKey(NewOpenPGPWrapper(openpgp.EntityList{e}), key, "", keysPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy, err := LoadRecipientPolicy(strings.NewReader(gpg.Fingerprint(alice) + "\nkeys/bob.asc\n" +
+		gpg.Fingerprint(carol) + " expires=2020-01-01\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := openpgp.EntityList{alice, carol}
+	plans, err := g.PlanSync(policy, repo, keyring, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := plans[0]
+	if len(plan.Add) != 1 || gpg.Fingerprint(plan.Add[0]) != gpg.Fingerprint(bob) {
+		t.Errorf("expected bob to be added, got %d additions", len(plan.Add))
+	}
+	if len(plan.Remove) != 1 || plan.Remove[0] != gpg.Fingerprint(carol) || len(plan.Expired) != 1 {
+		t.Errorf("expected expired carol to be removed, got %v", plan.Remove)
+	}
+
+	w := &OpenPGPWrapper{Signer: alice}
+	_, err = g.SyncRecipients(plan, key, w, repo, keyring, policy, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{gpg.Fingerprint(alice), gpg.Fingerprint(bob)}
+	sort.Strings(expected)
+	if got := wrappedRecipients(t, repo, "0"); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected recipients after sync %v", got)
+	}
+	plans, _ = g.PlanSync(policy, repo, keyring, time.Now())
+	if !plans[0].Empty() {
+		t.Error("expected nothing left to sync")
+	}
+
+	// Removing bob with rotation creates a new version for alice only
+	policy[""] = policy[""][:1]
+	plans, err = g.PlanSync(policy, repo, keyring, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := g.SyncRecipients(plans[0], key, w, repo, keyring, policy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated.Entries) != 2 || rotated.Entries[1].Version != 1 {
+		t.Fatalf("expected key to be rotated to version 1")
+	}
+	if got := wrappedRecipients(t, repo, "1"); len(got) != 1 || got[0] != gpg.Fingerprint(alice) {
+		t.Errorf("unexpected recipients of rotated key %v", got)
+	}
+	version, _ := g.LatestKeyVersion(keysPath, "")
+	if version != 1 {
+		t.Errorf("expected latest version 1, got %d", version)
+	}
+	unwrapped, err := g.DecryptRepoKey(openpgp.EntityList{alice}, "", 1, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := unwrapped.Latest()
+	if !bytes.Equal(latest.AesKey, rotated.Entries[1].AesKey) {
+		t.Error("rotated key does not unwrap")
+	}
+}
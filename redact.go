@@ -0,0 +1,252 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Formats understood by Redact.
+const (
+	RedactEnv  = "env"
+	RedactYAML = "yaml"
+	RedactJSON = "json"
+)
+
+// redactedPrefix marks a redacted value.
+const redactedPrefix = "redacted:"
+
+// RedactFormat guesses the format of a file from its name, returning an
+// empty string if it is not a format Redact understands. Names such as
+// "prod.env", ".env" and ".env.local" are env files.
+func RedactFormat(name string) string {
+	base := strings.ToLower(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	switch {
+	case strings.HasSuffix(base, ".env") || strings.HasPrefix(base, ".env."):
+		return RedactEnv
+	case strings.HasSuffix(base, ".yaml") || strings.HasSuffix(base, ".yml"):
+		return RedactYAML
+	case strings.HasSuffix(base, ".json"):
+		return RedactJSON
+	}
+	return ""
+}
+
+// RedactSalt derives the salt for redacted values from a repository key,
+// so that only key holders can produce or check them, and values do not
+// change their hashes as the key is rotated.
+func RedactSalt(key Key) []byte {
+	if len(key.Entries) == 0 {
+		return nil
+	}
+	oldest := key.Entries[0]
+	for _, e := range key.Entries {
+		if e.Version < oldest.Version {
+			oldest = e
+		}
+	}
+	h := hmac.New(sha256.New, oldest.HmacKey)
+	h.Write([]byte("go-git-crypt redact"))
+	return h.Sum(nil)
+}
+
+// Redact returns the structure of a file with every value replaced by a
+// salted hash, so reviewers can see which settings were added, removed or
+// changed, and which are equal, without seeing them. Keys and comments are
+// kept. format is one of RedactEnv, RedactYAML or RedactJSON; files in any
+// other format are summarised by their size and a hash.
+func Redact(plain []byte, format string, salt []byte) ([]byte, error) {
+	r := redactor{salt: salt}
+	switch format {
+	case RedactEnv:
+		return r.lines(plain, r.envLine), nil
+	case RedactYAML:
+		return r.lines(plain, r.yamlLine), nil
+	case RedactJSON:
+		return r.json(plain)
+	}
+	lines := bytes.Count(plain, []byte("\n"))
+	return []byte(fmt.Sprintf("[redacted file: %d bytes, %d lines, %s]\n", len(plain), lines, r.hash(string(plain)))), nil
+}
+
+type redactor struct {
+	salt []byte
+}
+
+// hash returns the redacted form of a value.
+func (r redactor) hash(value string) string {
+	h := hmac.New(sha256.New, r.salt)
+	h.Write([]byte(value))
+	return redactedPrefix + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// lines redacts a line based format, line by line.
+func (r redactor) lines(plain []byte, redactLine func(string) string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(plain))
+	scanner.Buffer(make([]byte, 0, 4096), len(plain)+1)
+	for scanner.Scan() {
+		out.WriteString(redactLine(scanner.Text()))
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// envLine redacts a KEY=value line, optionally prefixed with "export".
+func (r redactor) envLine(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return line
+	}
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return r.hash(line)
+	}
+	if value == "" {
+		return line
+	}
+	return key + "=" + r.hash(value)
+}
+
+// yamlLine redacts the value of a "key: value" or "- value" line. Lines
+// without a value, comments and document markers are kept; anything else,
+// such as the lines of block scalars, is redacted whole.
+func (r redactor) yamlLine(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" || trimmed == "..." {
+		return line
+	}
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	rest := trimmed
+
+	prefix := indent
+	for strings.HasPrefix(rest, "- ") || rest == "-" {
+		prefix += "- "
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, "-"))
+	}
+	if rest == "" {
+		return strings.TrimRight(prefix, " ")
+	}
+
+	if key, value, ok := cutYAMLKey(rest); ok {
+		value = strings.TrimSpace(value)
+		anchor := ""
+		if strings.HasPrefix(value, "&") {
+			anchor, value, _ = strings.Cut(value, " ")
+			anchor = " " + anchor
+			value = strings.TrimSpace(value)
+		}
+		if value == "" || strings.ContainsAny(value[:1], "|>#") {
+			// Nested mapping or block scalar: the lines which follow are
+			// redacted on their own
+			return prefix + key + ":" + anchor + optionalSpace(value)
+		}
+		return prefix + key + ":" + anchor + " " + r.hash(value)
+	}
+	return prefix + r.hash(rest)
+}
+
+// cutYAMLKey splits "key: value", allowing quoted keys.
+func cutYAMLKey(s string) (string, string, bool) {
+	if strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key := s[:end+2]
+		rest := s[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		return key, rest[1:], true
+	}
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		if strings.HasSuffix(s, ":") {
+			return s[:len(s)-1], "", true
+		}
+		return "", "", false
+	}
+	return s[:i], s[i+2:], true
+}
+
+func optionalSpace(s string) string {
+	if s == "" {
+		return ""
+	}
+	return " " + s
+}
+
+// json redacts a JSON document, keeping the order of object keys, and
+// writes it indented.
+func (r redactor) json(plain []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(plain))
+	dec.UseNumber()
+	var out bytes.Buffer
+	err := r.jsonValue(dec, &out, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("json: unexpected data after document")
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func (r redactor) jsonValue(dec *json.Decoder, out *bytes.Buffer, indent string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		closing := "}"
+		if t == '[' {
+			closing = "]"
+		}
+		out.WriteString(t.String())
+		first := true
+		for dec.More() {
+			if !first {
+				out.WriteByte(',')
+			}
+			first = false
+			out.WriteString("\n" + indent + "  ")
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				encoded, _ := json.Marshal(key)
+				out.Write(encoded)
+				out.WriteString(": ")
+			}
+			err = r.jsonValue(dec, out, indent+"  ")
+			if err != nil {
+				return err
+			}
+		}
+		if _, err = dec.Token(); err != nil {
+			return err
+		}
+		if !first {
+			out.WriteString("\n" + indent)
+		}
+		out.WriteString(closing)
+	case nil:
+		out.WriteString("null")
+	default:
+		encoded, _ := json.Marshal(r.hash(fmt.Sprint(t)))
+		out.Write(encoded)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_RedactFormat(t *testing.T) {
+	for name, format := range map[string]string{
+		"prod.env":                 RedactEnv,
+		".env":                     RedactEnv,
+		"config/.env.local":        RedactEnv,
+		"/tmp/Ab12Cd_secrets.yaml": RedactYAML,
+		"values.yml":               RedactYAML,
+		"creds.JSON":               RedactJSON,
+		"id_rsa":                   "",
+	} {
+		if got := RedactFormat(name); got != format {
+			t.Errorf("%s: expected %q, got %q", name, format, got)
+		}
+	}
+}
+
+func Test_Redact(t *testing.T) {
+	salt := []byte("salt")
+	r := redactor{salt: salt}
+
+	out, err := Redact([]byte("# database\nexport DB_USER=app\nDB_PASS=hunter2\nEMPTY=\nOTHER=hunter2\n"), RedactEnv, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# database\nexport DB_USER=" + r.hash("app") + "\nDB_PASS=" + r.hash("hunter2") + "\nEMPTY=\nOTHER=" + r.hash("hunter2") + "\n"
+	if string(out) != expected {
+		t.Errorf("unexpected env output:\n%s", out)
+	}
+
+	yaml := "---\ndatabase:\n  user: app # comment\n  password: &pw hunter2\n  hosts:\n    - db1\n    - name: db2\n  cert: |\n    BEGIN\n\"quoted key\": x\n"
+	out, err = Redact([]byte(yaml), RedactYAML, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"---\ndatabase:\n  user: redacted:",
+		"\n  password: &pw " + r.hash("hunter2") + "\n",
+		"\n  hosts:\n    - " + r.hash("db1") + "\n    - name: " + r.hash("db2") + "\n",
+		"\n  cert: |\n    " + r.hash("BEGIN") + "\n",
+		"\n\"quoted key\": " + r.hash("x") + "\n",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("yaml output lacks %q:\n%s", want, out)
+		}
+	}
+	if bytes.Contains(out, []byte("hunter2")) || bytes.Contains(out, []byte("app")) {
+		t.Errorf("yaml output leaks values:\n%s", out)
+	}
+
+	out, err = Redact([]byte(`{"user":"app","port":5432,"tls":true,"none":null,"hosts":["a","b"],"empty":{}}`), RedactJSON, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "{\n  \"user\": \"" + r.hash("app") + "\",\n  \"port\": \"" + r.hash("5432") + "\",\n  \"tls\": \"" + r.hash("true") +
+		"\",\n  \"none\": null,\n  \"hosts\": [\n    \"" + r.hash("a") + "\",\n    \"" + r.hash("b") + "\"\n  ],\n  \"empty\": {}\n}\n"
+	if string(out) != expected {
+		t.Errorf("unexpected json output:\n%s", out)
+	}
+	if _, err = Redact([]byte(`{"a": `), RedactJSON, salt); err == nil {
+		t.Error("malformed json redacted")
+	}
+
+	out, _ = Redact([]byte("-----BEGIN KEY-----\n"), "", salt)
+	if !strings.HasPrefix(string(out), "[redacted file: 20 bytes, 1 lines, redacted:") {
+		t.Errorf("unexpected summary %q", out)
+	}
+
+	// Hashes depend on the salt
+	other, _ := Redact([]byte("A=1\n"), RedactEnv, []byte("other"))
+	same, _ := Redact([]byte("A=1\n"), RedactEnv, salt)
+	if bytes.Equal(other, same) {
+		t.Error("salt ignored")
+	}
+}
+
+func Test_RedactedTextConv(t *testing.T) {
+	key := testRepoKey(t, "")
+	g := GitCrypt{}
+	var out bytes.Buffer
+	err := g.RedactedTextConv([]Key{key}, "/tmp/X_prod.env", bytes.NewReader(testEncrypt(t, key, "TOKEN=abc\n")), &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := redactor{salt: RedactSalt(key)}
+	if out.String() != "TOKEN="+r.hash("abc")+"\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	// Files are salted with the key protecting them, whichever keys are held
+	infra := testRepoKey(t, "infra")
+	r = redactor{salt: RedactSalt(infra)}
+	secret := testEncrypt(t, infra, "TOKEN=abc\n")
+	for _, keys := range [][]Key{{infra}, {key, infra}, {infra, key}} {
+		out.Reset()
+		err = g.RedactedTextConv(keys, "/tmp/X_prod.env", bytes.NewReader(secret), &out, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != "TOKEN="+r.hash("abc")+"\n" {
+			t.Errorf("unexpected output %q with %d keys", out.String(), len(keys))
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RewriteOptions selects what RewriteHistory rewrites.
+type RewriteOptions struct {
+	// Refs are the branches and tags to rewrite. All branches and tags are
+	// rewritten if empty.
+	Refs []string
+	// Paths are path patterns, as for path.Match, selecting the files to
+	// rewrite. Patterns without a slash also match the base name. If empty,
+	// the files marked for encryption by .gitattributes at each commit are
+	// rewritten.
+	Paths []string
+	// Keys are the keys files are encrypted with, chosen by the key name
+	// from .gitattributes, or KeyName when Paths is given. Files already
+	// encrypted are decrypted with any version of the key, and re-encrypted.
+	Keys []Key
+	// KeyName is the key used for files selected by Paths.
+	KeyName string
+	// KeyVersion is the key version files are encrypted with.
+	KeyVersion uint32
+	// KeyVersions optionally overrides KeyVersion by key name, for keys
+	// which are at different versions.
+	KeyVersions map[string]uint32
+	// Purge drops the selected files instead of encrypting them.
+	Purge bool
+}
+
+// RewriteHistory rewrites the commits reachable from opts.Refs so that the
+// selected files are encrypted under a chosen key version, or dropped if
+// opts.Purge is set. Commit metadata is preserved, except for signatures,
+// which no longer match. The rewritten refs are updated, their old values
+// kept under refs/original/ (replacing those of an earlier rewrite), and a
+// mapping of old to new commit ids is returned. The working tree and index
+// are left alone.
+func (g *GitCrypt) RewriteHistory(repoPath string, opts RewriteOptions) (map[string]string, error) {
+	entries := make(map[string]KeyEntry)
+	if !opts.Purge {
+		for _, key := range opts.Keys {
+			version, ok := opts.KeyVersions[key.KeyName]
+			if !ok {
+				version = opts.KeyVersion
+			}
+			entry, err := key.Get(version)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", keyDisplayName(key.KeyName), err.Error())
+			}
+			entries[key.KeyName] = entry
+		}
+		if len(opts.Paths) > 0 {
+			if _, ok := entries[opts.KeyName]; !ok {
+				return nil, fmt.Errorf("no key %q given", keyDisplayName(opts.KeyName))
+			}
+		}
+	}
+
+	refs := append([]string{}, opts.Refs...)
+	if len(refs) == 0 {
+		out, err := gitCommand(repoPath, nil, "for-each-ref", "--format=%(refname)", "refs/heads", "refs/tags")
+		if err != nil {
+			return nil, err
+		}
+		refs = strings.Fields(string(out))
+	} else {
+		for i, ref := range refs {
+			out, err := gitCommand(repoPath, nil, "rev-parse", "--symbolic-full-name", ref)
+			if err != nil {
+				return nil, err
+			}
+			full := strings.TrimSpace(string(out))
+			if !strings.HasPrefix(full, "refs/") {
+				return nil, fmt.Errorf("%s is not a branch or tag", ref)
+			}
+			refs[i] = full
+		}
+	}
+	if len(refs) == 0 {
+		return nil, errors.New("nothing to rewrite")
+	}
+
+	out, err := gitCommand(repoPath, nil, append([]string{"rev-list", "--reverse", "--topo-order"}, refs...)...)
+	if err != nil {
+		return nil, err
+	}
+	commits := strings.Fields(string(out))
+
+	tmp, err := os.MkdirTemp("", "go-git-crypt-rewrite")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	r := &historyRewriter{
+		g:        g,
+		repoPath: repoPath,
+		env:      []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")},
+		opts:     opts,
+		entries:  entries,
+		blobs:    make(map[string]string),
+		commits:  make(map[string]string),
+	}
+	r.reader, err = newGitBlobReader(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.reader.Close()
+
+	for _, commit := range commits {
+		err = r.rewriteCommit(commit)
+		if err != nil {
+			return r.commits, fmt.Errorf("%s: %s", commit, err.Error())
+		}
+	}
+
+	for _, ref := range refs {
+		err = r.updateRef(ref)
+		if err != nil {
+			return r.commits, err
+		}
+	}
+	return r.commits, nil
+}
+
+// historyRewriter holds the state of a history rewrite.
+type historyRewriter struct {
+	g        *GitCrypt
+	repoPath string
+	env      []string
+	opts     RewriteOptions
+	entries  map[string]KeyEntry
+	reader   *gitBlobReader
+	// blobs maps key name and old blob to the new blob
+	blobs map[string]string
+	// commits maps old commits to new commits
+	commits map[string]string
+}
+
+func (r *historyRewriter) git(stdin []byte, args ...string) (string, error) {
+	var in io.Reader
+	if stdin != nil {
+		in = bytes.NewReader(stdin)
+	}
+	out, err := gitCommandEnv(r.repoPath, r.env, in, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+func (r *historyRewriter) rewriteCommit(commit string) error {
+	files, err := gitListFiles(r.repoPath, commit)
+	if err != nil {
+		return err
+	}
+	_, err = r.git(nil, "read-tree", commit)
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[string]string)
+	if len(r.opts.Paths) > 0 {
+		for _, f := range files {
+			if matchAnyPath(r.opts.Paths, f.Path) {
+				selected[f.Path] = r.opts.KeyName
+			}
+		}
+	} else {
+		paths := make([]string, len(files))
+		for i, f := range files {
+			paths[i] = f.Path
+		}
+		attrs, err := gitFilterAttributes(r.repoPath, r.env, paths, true)
+		if err != nil {
+			return err
+		}
+		for p, filter := range attrs {
+			if keyName, ok := filterKeyName(filter); ok {
+				selected[p] = keyName
+			}
+		}
+	}
+
+	var info bytes.Buffer
+	for _, f := range files {
+		keyName, ok := selected[f.Path]
+		if !ok {
+			continue
+		}
+		if r.opts.Purge {
+			// A zero mode removes the path from the index
+			fmt.Fprintf(&info, "0 %s\t%s\x00", strings.Repeat("0", len(f.Blob)), f.Path)
+			continue
+		}
+		blob, err := r.rewriteBlob(f, keyName)
+		if err != nil {
+			return err
+		}
+		if blob != f.Blob {
+			fmt.Fprintf(&info, "%s %s\t%s\x00", f.Mode, blob, f.Path)
+		}
+	}
+	if info.Len() > 0 {
+		_, err = r.git(info.Bytes(), "update-index", "-z", "--index-info")
+		if err != nil {
+			return err
+		}
+	}
+	tree, err := r.git(nil, "write-tree")
+	if err != nil {
+		return err
+	}
+
+	raw, err := r.reader.Read(commit)
+	if err != nil {
+		return err
+	}
+	rewritten := rewriteCommitObject(raw, tree, r.commits)
+	if bytes.Equal(rewritten, raw) {
+		r.commits[commit] = commit
+		return nil
+	}
+	id, err := r.git(rewritten, "hash-object", "-t", "commit", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	r.commits[commit] = id
+	r.g.logger().Debug("rewrote commit", "commit", commit, "rewritten", id)
+	return nil
+}
+
+// rewriteBlob returns the blob holding a file encrypted under the chosen
+// key version, writing it if needed.
+func (r *historyRewriter) rewriteBlob(f gitFile, keyName string) (string, error) {
+	entry, ok := r.entries[keyName]
+	if !ok {
+		return "", fmt.Errorf("%s: no key %q given", f.Path, keyDisplayName(keyName))
+	}
+	cached := keyName + "\x00" + f.Blob
+	if blob, ok := r.blobs[cached]; ok {
+		return blob, nil
+	}
+
+	data, err := r.reader.Read(f.Blob)
+	if err != nil {
+		return "", err
+	}
+	plain := data
+	if bytes.HasPrefix(data, gitCryptHeader) {
+		plain = nil
+		for _, key := range r.opts.Keys {
+			if key.KeyName != keyName {
+				continue
+			}
+			for _, e := range key.Entries {
+				if p, err := decryptBlob(e, data); err == nil {
+					plain = p
+					break
+				}
+			}
+		}
+		if plain == nil {
+			return "", fmt.Errorf("%s: cannot decrypt with key %q", f.Path, keyDisplayName(keyName))
+		}
+	}
+
+	blob := f.Blob
+	if len(data) > 0 {
+		encrypted, err := encryptBlob(entry, plain)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(encrypted, data) {
+			blob, err = r.git(encrypted, "hash-object", "-w", "--stdin")
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	r.blobs[cached] = blob
+	return blob, nil
+}
+
+// updateRef points a ref at its rewritten commit, keeping the old value
+// under refs/original/. Annotated tags are recreated, without signatures.
+func (r *historyRewriter) updateRef(ref string) error {
+	old, err := r.git(nil, "rev-parse", ref)
+	if err != nil {
+		return err
+	}
+	kind, err := r.git(nil, "cat-file", "-t", old)
+	if err != nil {
+		return err
+	}
+
+	var updated string
+	switch kind {
+	case "commit":
+		updated = r.commits[old]
+	case "tag":
+		raw, err := r.reader.Read(old)
+		if err != nil {
+			return err
+		}
+		target, rest, _ := bytes.Cut(raw, []byte("\n"))
+		commit, ok := r.commits[strings.TrimPrefix(string(target), "object ")]
+		if !ok || "object "+commit == string(target) {
+			return nil
+		}
+		tag := []byte("object " + commit + "\n")
+		tag = append(tag, rest...)
+		if i := bytes.Index(tag, []byte("-----BEGIN PGP SIGNATURE-----")); i >= 0 {
+			tag = tag[:i]
+		}
+		updated, err = r.git(tag, "hash-object", "-t", "tag", "-w", "--stdin")
+		if err != nil {
+			return err
+		}
+	}
+	if updated == "" || updated == old {
+		return nil
+	}
+	_, err = r.git(nil, "update-ref", "refs/original/"+strings.TrimPrefix(ref, "refs/"), old)
+	if err != nil {
+		return err
+	}
+	_, err = r.git(nil, "update-ref", ref, updated, old)
+	return err
+}
+
+// rewriteCommitObject replaces the tree and parents of a raw commit object,
+// dropping its signature.
+func rewriteCommitObject(raw []byte, tree string, commits map[string]string) []byte {
+	header, message, _ := bytes.Cut(raw, []byte("\n\n"))
+	var out bytes.Buffer
+	inSignature := false
+	for _, line := range strings.Split(string(header), "\n") {
+		if inSignature && strings.HasPrefix(line, " ") {
+			continue
+		}
+		inSignature = false
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			line = "tree " + tree
+		case strings.HasPrefix(line, "parent "):
+			if parent, ok := commits[strings.TrimPrefix(line, "parent ")]; ok {
+				line = "parent " + parent
+			}
+		case strings.HasPrefix(line, "gpgsig"):
+			inSignature = true
+			continue
+		}
+		out.WriteString(line + "\n")
+	}
+	out.WriteString("\n")
+	out.Write(message)
+	return out.Bytes()
+}
+
+// matchAnyPath reports whether a path matches any of the patterns.
+func matchAnyPath(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyDisplayName returns the name of a key as shown to users.
+func keyDisplayName(keyName string) string {
+	if keyName == "" {
+		return "default"
+	}
+	return keyName
+}
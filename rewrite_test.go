@@ -0,0 +1,116 @@
+package gitcrypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_RewriteHistory(t *testing.T) {
+	key := testRepoKey(t, "")
+	rotated := key
+	rotated.Entries = append([]KeyEntry{}, key.Entries...)
+	next := KeyEntry{}
+	if err := next.Generate(1); err != nil {
+		t.Fatal(err)
+	}
+	rotated.Entries = append(rotated.Entries, next)
+
+	repo := testGitRepo(t, map[string][]byte{
+		"README":     []byte("hello"),
+		"secret.env": []byte("PASSWORD=hunter2"),
+	}, true)
+	first := strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD"))
+	testWriteFiles(t, repo, map[string][]byte{
+		".gitattributes": []byte("*.env filter=git-crypt diff=git-crypt\n"),
+		"other.env":      testEncrypt(t, key, "TOKEN=abc"),
+	})
+	testGit(t, repo, "add", ".")
+	testGit(t, repo, "commit", "-q", "-m", "encrypt env files")
+	testGit(t, repo, "tag", "-a", "-m", "release", "v1")
+	old := strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD"))
+	subject := testGit(t, repo, "log", "-1", "--format=%an %ae %ad %s", "HEAD")
+
+	g := GitCrypt{}
+	mapping, err := g.RewriteHistory(repo, RewriteOptions{Keys: []Key{rotated}, KeyVersion: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapping) != 2 {
+		t.Fatalf("expected 2 commits, got %v", mapping)
+	}
+	// Nothing was marked for encryption in the first commit
+	if mapping[first] != first {
+		t.Errorf("first commit should not change, got %s", mapping[first])
+	}
+	head := strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD"))
+	if head == old || mapping[old] != head {
+		t.Errorf("HEAD %s not rewritten to %s", head, mapping[old])
+	}
+	if testGit(t, repo, "log", "-1", "--format=%an %ae %ad %s", "HEAD") != subject {
+		t.Error("commit metadata not preserved")
+	}
+	branch := strings.TrimSpace(testGit(t, repo, "symbolic-ref", "HEAD"))
+	original := "refs/original/" + strings.TrimPrefix(branch, "refs/")
+	if strings.TrimSpace(testGit(t, repo, "rev-parse", original)) != old {
+		t.Errorf("original ref %s not kept", original)
+	}
+	if strings.TrimSpace(testGit(t, repo, "rev-parse", "v1^{commit}")) != head {
+		t.Error("tag not rewritten")
+	}
+
+	// Every file is now encrypted under the new version only
+	results, err := g.Verify(repo, []Key{{KeyName: "", Entries: []KeyEntry{next}}}, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || len(VerifyFailed(results)) != 0 {
+		t.Errorf("unexpected verification results %v", results)
+	}
+
+	// Purging drops the files; the caller's refs are left alone
+	refs := []string{strings.TrimPrefix(branch, "refs/heads/"), "v1"}
+	_, err = g.RewriteHistory(repo, RewriteOptions{Refs: refs, Paths: []string{"*.env"}, Purge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs[0] != strings.TrimPrefix(branch, "refs/heads/") || refs[1] != "v1" {
+		t.Errorf("refs modified: %v", refs)
+	}
+	files := testGit(t, repo, "log", "--branches", "--tags", "--name-only", "--format=", "--", "*.env")
+	if strings.TrimSpace(files) != "" {
+		t.Errorf("files not purged: %s", files)
+	}
+}
+
+func Test_RewriteHistoryKeyVersions(t *testing.T) {
+	key := testRepoKey(t, "")
+	next := KeyEntry{}
+	if err := next.Generate(1); err != nil {
+		t.Fatal(err)
+	}
+	key.Entries = append(key.Entries, next)
+	infra := testRepoKey(t, "infra")
+
+	repo := testGitRepo(t, map[string][]byte{
+		".gitattributes": []byte("*.env filter=git-crypt\ninfra/** filter=git-crypt-infra\n"),
+		"secret.env":     testEncrypt(t, key, "PASSWORD=hunter2"),
+		"infra/state":    testEncrypt(t, infra, "terraform state"),
+	}, true)
+
+	g := GitCrypt{}
+	_, err := g.RewriteHistory(repo, RewriteOptions{Keys: []Key{key, infra}, KeyVersion: 1})
+	if err == nil {
+		t.Error("expected a missing version of the infra key to fail")
+	}
+	_, err = g.RewriteHistory(repo, RewriteOptions{Keys: []Key{key, infra}, KeyVersions: map[string]uint32{"": 1, "infra": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := g.Verify(repo, []Key{{Entries: []KeyEntry{next}}, infra}, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || len(VerifyFailed(results)) != 0 {
+		t.Errorf("unexpected verification results %v", results)
+	}
+}
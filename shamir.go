@@ -0,0 +1,124 @@
+package gitcrypt
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Shamir secret sharing over GF(2^8), using the AES reducing polynomial.
+
+var (
+	gf256Exp [510]byte
+	gf256Log [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gf256Exp[i] = x
+		gf256Exp[i+255] = x
+		gf256Log[x] = byte(i)
+		// Multiply by the generator 3
+		x ^= gf256MulSlow(x, 2)
+	}
+}
+
+func gf256MulSlow(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gf256Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+int(gf256Log[b])]
+}
+
+func gf256Div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+255-int(gf256Log[b])]
+}
+
+// shamirSplit splits secret into n shares, any threshold of which can be
+// combined to recover it. Share i is evaluated at x = i+1, and is returned
+// as that x coordinate followed by one y value per secret byte.
+func shamirSplit(secret []byte, n int, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, errors.New("shamir: need 2 <= threshold <= shares <= 255")
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+	coefficients := make([]byte, threshold)
+	for pos, s := range secret {
+		_, err := rand.Read(coefficients[1:])
+		if err != nil {
+			return nil, err
+		}
+		coefficients[0] = s
+		for i := range shares {
+			x := shares[i][0]
+			// Horner's method
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gf256Mul(y, x) ^ coefficients[c]
+			}
+			shares[i][pos+1] = y
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine recovers a secret from shares produced by shamirSplit.
+// Supplying fewer than the threshold number of shares silently produces
+// the wrong secret, so callers must verify the result.
+func shamirCombine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("shamir: need at least 2 shares")
+	}
+	length := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if len(s) != length || length < 2 {
+			return nil, errors.New("shamir: shares have inconsistent lengths")
+		}
+		if s[0] == 0 || seen[s[0]] {
+			return nil, errors.New("shamir: duplicate or invalid share")
+		}
+		seen[s[0]] = true
+	}
+
+	secret := make([]byte, length-1)
+	for pos := range secret {
+		// Lagrange interpolation at x = 0
+		var value byte
+		for i, si := range shares {
+			basis := byte(1)
+			for j, sj := range shares {
+				if i == j {
+					continue
+				}
+				basis = gf256Mul(basis, gf256Div(sj[0], sj[0]^si[0]))
+			}
+			value ^= gf256Mul(si[pos+1], basis)
+		}
+		secret[pos] = value
+	}
+	return secret, nil
+}
@@ -0,0 +1,177 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+// keyShareMagic prefixes every decrypted key share.
+var keyShareMagic = []byte("\x00GITCRYPTSHARE")
+
+// shareDirectory returns the directory holding the wrapped key shares for
+// a key name and version.
+func shareDirectory(keysPath string, keyName string, keyVersion uint32) string {
+	return keyDirectory(keysPath, keyName, keyVersion) + string(os.PathSeparator) + "shares"
+}
+
+// SplitRepoKey splits the latest entry of a repository key into one share
+// per recipient, any threshold of which can reconstruct it, for
+// break-glass access where no single person can unlock alone. Each share
+// is wrapped for its recipient with gpg.Encrypt and stored as
+// $REPOPATH/.git-crypt/keys/<name>/<version>/shares/<fingerprint>.gpg.
+// The paths of the written shares are returned.
+func (g *GitCrypt) SplitRepoKey(key Key, threshold int, recipients openpgp.EntityList, keysPath string) ([]string, error) {
+	paths := make([]string, 0)
+	entry, err := key.Latest()
+	if err != nil {
+		return paths, err
+	}
+
+	var plain bytes.Buffer
+	defer func() { wipe(plain.Bytes()) }()
+	err = Key{KeyName: key.KeyName, Entries: []KeyEntry{entry}}.Store(&plain)
+	if err != nil {
+		return paths, err
+	}
+	shares, err := shamirSplit(plain.Bytes(), len(recipients), threshold)
+	if err != nil {
+		return paths, err
+	}
+
+	dir := shareDirectory(keysPath, key.KeyName, entry.Version)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return paths, err
+	}
+	for i, recipient := range recipients {
+		var share bytes.Buffer
+		share.Write(keyShareMagic)
+		err = writeBigEndianUint32(&share, uint32(threshold))
+		if err != nil {
+			return paths, err
+		}
+		share.Write(shares[i])
+
+		wrapped, err := gpg.Encrypt(share.Bytes(), openpgp.EntityList{recipient}, gpg.EntityID(recipient), "")
+		wipe(share.Bytes())
+		wipe(shares[i])
+		if err != nil {
+			return paths, err
+		}
+		path := dir + string(os.PathSeparator) + gpg.Fingerprint(recipient) + ".gpg"
+		err = os.WriteFile(path, wrapped, 0600)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// DecryptKeyShares decrypts every key share for a key name and version
+// which can be decrypted with keyring. The decrypted shares can be
+// combined with those decrypted by other custodians using
+// CombineKeyShares.
+func (g *GitCrypt) DecryptKeyShares(keyring openpgp.EntityList, keyName string, keyVersion uint32, keysPath string) ([][]byte, error) {
+	shares := make([][]byte, 0)
+	dir := shareDirectory(keysPath, keyName, keyVersion)
+	names, err := g.readDirNames(dir)
+	if err != nil {
+		return shares, err
+	}
+	w := g.openPGPWrapper(keyring)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".gpg") {
+			continue
+		}
+		wrapped, err := g.readFile(dir + string(os.PathSeparator) + name)
+		if err != nil {
+			return shares, err
+		}
+		share, err := w.Unwrap(wrapped, strings.TrimSuffix(name, ".gpg"))
+		if err != nil {
+			g.logger().Debug("unable to decrypt key share", "share", name, "err", err)
+			continue
+		}
+		shares = append(shares, share)
+	}
+	if len(shares) == 0 {
+		return shares, errors.New("no key shares could be decrypted")
+	}
+	return shares, nil
+}
+
+// CombineKeyShares reconstructs a repository key from decrypted key
+// shares, which must number at least the threshold the key was split
+// with. Duplicate shares are ignored.
+func CombineKeyShares(shares [][]byte) (Key, error) {
+	threshold := uint32(0)
+	points := make([][]byte, 0)
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if !bytes.HasPrefix(share, keyShareMagic) {
+			return Key{}, errors.New("malformed key share")
+		}
+		r := bytes.NewReader(share[len(keyShareMagic):])
+		t, err := readBigEndianUint32(r)
+		if err != nil {
+			return Key{}, errors.New("malformed key share")
+		}
+		if threshold != 0 && t != threshold {
+			return Key{}, errors.New("key shares come from different splits")
+		}
+		threshold = t
+		point, err := io.ReadAll(r)
+		if err != nil || len(point) < 2 {
+			return Key{}, errors.New("malformed key share")
+		}
+		if seen[point[0]] {
+			continue
+		}
+		seen[point[0]] = true
+		points = append(points, point)
+	}
+	if uint32(len(points)) < threshold || len(points) < 2 {
+		return Key{}, fmt.Errorf("need %d key shares, only have %d", threshold, len(points))
+	}
+
+	secret, err := shamirCombine(points)
+	if err != nil {
+		return Key{}, err
+	}
+	var key Key
+	err = key.Load(bytes.NewReader(secret))
+	wipe(secret)
+	if err != nil || len(key.Entries) == 0 {
+		return Key{}, errors.New("key shares do not reconstruct a valid key")
+	}
+	return key, nil
+}
+
+// UnlockWithShares unlocks a repository key from its shares, combining the
+// shares keyring can decrypt with shares already decrypted by other
+// custodians.
+func (g *GitCrypt) UnlockWithShares(keyring openpgp.EntityList, keyName string, keyVersion uint32, keysPath string, others [][]byte) (Key, error) {
+	shares, err := g.DecryptKeyShares(keyring, keyName, keyVersion, keysPath)
+	if err != nil && len(others) == 0 {
+		return Key{}, err
+	}
+	key, err := CombineKeyShares(append(shares, others...))
+	if err != nil {
+		return Key{}, err
+	}
+	if key.KeyName != keyName {
+		return Key{}, fmt.Errorf("key shares do not contain expected key name: %w", ErrKeyNameMismatch)
+	}
+	if _, err := key.Get(keyVersion); err != nil {
+		return Key{}, errors.New("key shares do not contain expected key version")
+	}
+	return key, nil
+}
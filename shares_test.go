@@ -0,0 +1,76 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func Test_Shamir(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := shamirSplit(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subset := range [][][]byte{
+		{shares[0], shares[1], shares[2]},
+		{shares[4], shares[2], shares[0]},
+		shares,
+	} {
+		out, err := shamirCombine(subset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, secret) {
+			t.Errorf("combined secret %q does not match", out)
+		}
+	}
+	out, _ := shamirCombine(shares[:2])
+	if bytes.Equal(out, secret) {
+		t.Error("secret recovered from fewer than threshold shares")
+	}
+}
+
+func Test_UnlockWithShares(t *testing.T) {
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+	alice := testEntity(t, "alice")
+	bob := testEntity(t, "bob")
+	carol := testEntity(t, "carol")
+
+	key := testRepoKey(t, "prod")
+	paths, err := g.SplitRepoKey(key, 2, openpgp.EntityList{alice, bob, carol}, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("expected 3 shares, got %d", len(paths))
+	}
+
+	// A single custodian cannot unlock alone
+	_, err = g.UnlockWithShares(openpgp.EntityList{alice}, "prod", 0, keysPath, nil)
+	if err == nil {
+		t.Fatal("expected unlock with one share to fail")
+	}
+
+	bobShares, err := g.DecryptKeyShares(openpgp.EntityList{bob}, "prod", 0, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocked, err := g.UnlockWithShares(openpgp.EntityList{alice}, "prod", 0, keysPath, bobShares)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unlocked.Entries[0].AesKey, key.Entries[0].AesKey) ||
+		!bytes.Equal(unlocked.Entries[0].HmacKey, key.Entries[0].HmacKey) {
+		t.Error("reconstructed key does not match original")
+	}
+
+	// Duplicate shares do not count towards the threshold
+	_, err = g.UnlockWithShares(openpgp.EntityList{bob}, "prod", 0, keysPath, bobShares)
+	if err == nil {
+		t.Error("expected duplicate shares to be rejected")
+	}
+}
@@ -0,0 +1,293 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDiffCacheSize is the default bound of a DiffCache.
+const DefaultDiffCacheSize = 32 << 20
+
+// binaryCheckLen is how much of a file is checked for NUL bytes to decide
+// whether it is binary, as git does.
+const binaryCheckLen = 8000
+
+// DiffCache caches TextConv output by blob id, so diffs and logs do not
+// decrypt the same blobs over and over. It holds plain text, so it lives
+// inside the git directory, readable only by its owner. Entries are also
+// keyed by the key which decrypted them, and are only used while that key
+// is available; they stay on disk until Clear is called, which should be
+// done when the repository is locked.
+type DiffCache struct {
+	Dir string
+	// MaxBytes bounds the size of the cache. The least recently used
+	// entries are removed once it grows beyond this.
+	MaxBytes int64
+}
+
+// OpenDiffCache opens the diff cache of a repository, in
+// .git/git-crypt/cache, creating it if needed. The cache holds decrypted
+// plain text until it is cleared.
+func (g *GitCrypt) OpenDiffCache(repoPath string) (*DiffCache, error) {
+	out, err := gitCommand(repoPath, nil, "rev-parse", "--git-path", "git-crypt/cache")
+	if err != nil {
+		return nil, err
+	}
+	dir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repoPath, dir)
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &DiffCache{Dir: dir, MaxBytes: DefaultDiffCacheSize}, nil
+}
+
+// Get returns the cached output for a blob.
+func (c *DiffCache) Get(id string) ([]byte, bool) {
+	path := filepath.Join(c.Dir, id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	// Mark as recently used
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Put caches the output for a blob, then trims the cache to MaxBytes.
+func (c *DiffCache) Put(id string, data []byte) error {
+	if int64(len(data)) > c.MaxBytes {
+		return nil
+	}
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.Dir, id))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.prune()
+}
+
+// Clear removes every cached entry.
+func (c *DiffCache) Clear() error {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = os.Remove(filepath.Join(c.Dir, e.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune removes the least recently used entries until the cache fits in
+// MaxBytes.
+func (c *DiffCache) prune() error {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, info := range infos {
+		if total <= c.MaxBytes {
+			break
+		}
+		err = os.Remove(filepath.Join(c.Dir, info.Name()))
+		if err != nil {
+			return err
+		}
+		total -= info.Size()
+	}
+	return nil
+}
+
+// TextConv writes a git-crypted file in a form fit for diffs, as a git
+// textconv filter. The file is decrypted with DecryptStream using whichever
+// of keys matches; binary plain text is summarised by its size and SHA-256
+// hash. A marker line is written instead of failing if the file cannot be
+// decrypted, so that diffs of locked repositories still work. Files which
+// are not encrypted are written as they are.
+//
+// Successful output is cached by blob id and key in cache, if it is not
+// nil, and only read back with the same key.
+func (g *GitCrypt) TextConv(keys []Key, in io.Reader, out io.Writer, cache *DiffCache) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, gitCryptHeader) {
+		_, err = out.Write(data)
+		return err
+	}
+
+	id := gitBlobID(data)
+	if cached, ok := cache.lookup(keys, id); ok {
+		_, err = out.Write(cached)
+		return err
+	}
+
+	plain, _, entry, marker := g.textConvDecrypt(keys, data, id)
+	if plain == nil {
+		_, err = io.WriteString(out, marker)
+		return err
+	}
+
+	result := plain
+	if isBinary(result) {
+		sum := sha256.Sum256(result)
+		result = []byte("Binary file, " + strconv.Itoa(len(result)) + " bytes, sha256 " + hex.EncodeToString(sum[:]) + "\n")
+	}
+	if cache != nil {
+		err = cache.Put(diffCacheID(id, entry), result)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = out.Write(result)
+	return err
+}
+
+// RedactedTextConv is TextConv for review artifacts: files in a format
+// Redact understands, guessed from name, are shown with their values
+// redacted, and any others summarised. Files which are not encrypted are
+// written as they are.
+func (g *GitCrypt) RedactedTextConv(keys []Key, name string, in io.Reader, out io.Writer, cache *DiffCache) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, gitCryptHeader) {
+		_, err = out.Write(data)
+		return err
+	}
+
+	format := RedactFormat(name)
+	id := gitBlobID(data)
+	cacheID := id + "-redacted-" + format
+	if cached, ok := cache.lookup(keys, cacheID); ok {
+		_, err = out.Write(cached)
+		return err
+	}
+
+	plain, key, entry, marker := g.textConvDecrypt(keys, data, id)
+	if plain == nil {
+		_, err = io.WriteString(out, marker)
+		return err
+	}
+	// Only holders of the key which protects the file can check its hashes
+	salt := RedactSalt(key)
+	if isBinary(plain) {
+		format = ""
+	}
+	result, err := Redact(plain, format, salt)
+	if err != nil {
+		// Malformed files are still worth a summary
+		result, _ = Redact(plain, "", salt)
+	}
+	if cache != nil {
+		err = cache.Put(diffCacheID(cacheID, entry), result)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = out.Write(result)
+	return err
+}
+
+// textConvDecrypt decrypts a git-crypted file for TextConv, returning the
+// plain text and the key and entry which decrypted it, or a marker line
+// saying why it could not be decrypted.
+func (g *GitCrypt) textConvDecrypt(keys []Key, data []byte, id string) ([]byte, Key, KeyEntry, string) {
+	if len(data) < gitCryptHeaderLen {
+		return nil, Key{}, KeyEntry{}, fmt.Sprintf("[git-crypt: truncated encrypted file, %d bytes]\n", len(data))
+	}
+	for _, key := range keys {
+		for _, entry := range key.Entries {
+			plain, err := decryptBlob(entry, data)
+			if err == nil {
+				return plain, key, entry, ""
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, Key{}, KeyEntry{}, fmt.Sprintf("[git-crypt: encrypted file %.12s, repository is locked]\n", id)
+	}
+	return nil, Key{}, KeyEntry{}, fmt.Sprintf("[git-crypt: encrypted file %.12s cannot be decrypted: tampered with, or encrypted with an unavailable key]\n", id)
+}
+
+// lookup returns the cached output for id under any of keys. A nil cache
+// holds nothing.
+func (c *DiffCache) lookup(keys []Key, id string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	for _, key := range keys {
+		for _, entry := range key.Entries {
+			if cached, ok := c.Get(diffCacheID(id, entry)); ok {
+				return cached, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// diffCacheID returns the cache id of output for id decrypted with entry,
+// so that it can only be found by holders of the key.
+func diffCacheID(id string, entry KeyEntry) string {
+	h := hmac.New(sha256.New, entry.HmacKey)
+	h.Write([]byte("go-git-crypt diff cache"))
+	return id + "-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// gitBlobID returns the git (SHA-1) object id of a blob.
+func gitBlobID(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isBinary reports whether data looks binary to git: whether it holds a
+// NUL byte near the start.
+func isBinary(data []byte) bool {
+	if len(data) > binaryCheckLen {
+		data = data[:binaryCheckLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
@@ -0,0 +1,94 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_TextConv(t *testing.T) {
+	key := testRepoKey(t, "")
+	other := testRepoKey(t, "")
+	repo := testGitRepo(t, map[string][]byte{"README": []byte("hello")}, false)
+
+	g := GitCrypt{}
+	cache, err := g.OpenDiffCache(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Dir != filepath.Join(repo, ".git", "git-crypt", "cache") {
+		t.Errorf("unexpected cache directory %s", cache.Dir)
+	}
+
+	conv := func(keys []Key, data []byte) string {
+		var out bytes.Buffer
+		err := g.TextConv(keys, bytes.NewReader(data), &out, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	secret := testEncrypt(t, key, "TOKEN=abc\n")
+	if out := conv([]Key{other, key}, secret); out != "TOKEN=abc\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	id := gitBlobID(secret)
+	if cached, ok := cache.lookup([]Key{key}, id); !ok || string(cached) != "TOKEN=abc\n" {
+		t.Error("output not cached")
+	}
+	// Only served from the cache to holders of the key
+	if _, ok := cache.lookup([]Key{other}, id); ok {
+		t.Error("output cached for another key")
+	}
+	if out := conv(nil, secret); !strings.Contains(out, "locked") {
+		t.Errorf("unexpected cached output without keys %q", out)
+	}
+
+	out := conv([]Key{key}, testEncrypt(t, key, "PNG\x00\x01\x02"))
+	if !strings.HasPrefix(out, "Binary file, 6 bytes, sha256 ") {
+		t.Errorf("unexpected binary summary %q", out)
+	}
+
+	tampered := testEncrypt(t, key, "TOKEN=def\n")
+	tampered[len(tampered)-1] ^= 1
+	if out := conv([]Key{key}, tampered); !strings.Contains(out, "cannot be decrypted") {
+		t.Errorf("unexpected output for tampered file %q", out)
+	}
+	if out := conv(nil, testEncrypt(t, key, "TOKEN=ghi\n")); !strings.Contains(out, "locked") {
+		t.Errorf("unexpected output for locked repository %q", out)
+	}
+	if out := conv(nil, []byte("plain")); out != "plain" {
+		t.Errorf("unexpected output for plain file %q", out)
+	}
+	if _, ok := cache.lookup([]Key{key}, gitBlobID(tampered)); ok {
+		t.Error("failure cached")
+	}
+
+	// The cache stays within its bound, dropping the oldest entries
+	cache.MaxBytes = 25
+	conv([]Key{key}, testEncrypt(t, key, "0123456789\n"))
+	conv([]Key{key}, testEncrypt(t, key, "abcdefghij\n"))
+	entries, _ := os.ReadDir(cache.Dir)
+	var total int64
+	for _, e := range entries {
+		info, _ := e.Info()
+		total += info.Size()
+	}
+	if total > cache.MaxBytes {
+		t.Errorf("cache holds %d bytes", total)
+	}
+	if _, ok := cache.lookup([]Key{key}, id); ok {
+		t.Error("oldest entry kept")
+	}
+
+	if err = cache.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = os.ReadDir(cache.Dir)
+	if len(entries) != 0 {
+		t.Errorf("cache not cleared: %v", entries)
+	}
+}
@@ -1,11 +1,12 @@
 package gitcrypt
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"os"
+	"runtime"
 )
 
 func (g *GitCrypt) fileExists(name string) bool {
@@ -25,6 +26,50 @@ func (g *GitCrypt) fileExists(name string) bool {
 	return true
 }
 
+// readFile reads an entire file, using the optional Vfs if it is present.
+func (g *GitCrypt) readFile(name string) ([]byte, error) {
+	if g.Vfs == nil {
+		return os.ReadFile(name)
+	}
+	fp, err := g.Vfs.Open(name)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer fp.Close()
+	return io.ReadAll(fp)
+}
+
+// readDirNames lists the names of the entries in a directory, using the
+// optional Vfs if it is present.
+func (g *GitCrypt) readDirNames(name string) ([]string, error) {
+	names := make([]string, 0)
+	if g.Vfs == nil {
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return names, err
+		}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names, nil
+	}
+	entries, err := g.Vfs.ReadDir(name)
+	if err != nil {
+		return names, err
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// wipe overwrites b with zeros, for buffers which held key material or
+// plain text.
+func wipe(b []byte) {
+	clear(b)
+	runtime.KeepAlive(b)
+}
+
 func readBigEndianUint32(in io.Reader) (uint32, error) {
 	data := make([]byte, 4)
 	n, err := in.Read(data)
@@ -82,10 +127,13 @@ func stringToASCIIBytes(s string) []byte {
 	return out
 }
 
+// randomBytes returns cryptographically secure random bytes, suitable for
+// key material.
 func randomBytes(length uint32) []byte {
 	out := make([]byte, length)
-	for i := 0; i < int(length); i++ {
-		out[i] = byte(rand.Intn(255))
+	_, err := rand.Read(out)
+	if err != nil {
+		panic("git-crypt: unable to read random bytes: " + err.Error())
 	}
 	return out
 }
@@ -0,0 +1,235 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitCryptHeaderLen is the length of the header of a git-crypted file: the
+// magic, a zero byte and the nonce.
+const gitCryptHeaderLen = 10 + aesEncryptorNonceLen
+
+// VerifyStatus is the outcome of verifying a single file.
+type VerifyStatus int
+
+const (
+	// VerifyOK means the file decrypted and its HMAC matched.
+	VerifyOK VerifyStatus = iota
+	// VerifyTampered means the key the file should be encrypted with is
+	// available, but the HMAC did not match.
+	VerifyTampered
+	// VerifyTruncated means the file is too short to hold its contents,
+	// such as a file holding only the header.
+	VerifyTruncated
+	// VerifyNoKey means none of the available keys decrypt the file.
+	VerifyNoKey
+	// VerifyDoubleEncrypted means the decrypted contents are themselves
+	// git-crypted.
+	VerifyDoubleEncrypted
+	// VerifyNotEncrypted means the file should be encrypted according to
+	// .gitattributes, but is stored in plain text.
+	VerifyNotEncrypted
+)
+
+func (s VerifyStatus) String() string {
+	switch s {
+	case VerifyOK:
+		return "ok"
+	case VerifyTampered:
+		return "tampered"
+	case VerifyTruncated:
+		return "truncated"
+	case VerifyNoKey:
+		return "no key"
+	case VerifyDoubleEncrypted:
+		return "double encrypted"
+	case VerifyNotEncrypted:
+		return "not encrypted"
+	}
+	return "unknown"
+}
+
+// VerifyResult is the outcome of verifying a file.
+type VerifyResult struct {
+	Path string
+	// KeyName is the key the file is encrypted with, according to
+	// .gitattributes, or the key which decrypted it
+	KeyName string
+	Status  VerifyStatus
+}
+
+// Verify checks the integrity of every git-crypted file in a repository,
+// decrypting each one with keys and checking its HMAC, without writing the
+// plain text anywhere. source selects what is checked: "" for the working
+// tree, "index" for the index, or any commit-ish. Files marked for
+// encryption in .gitattributes but stored in plain text are reported for
+// the index and commits, where they would leak.
+//
+// A result is returned for every git-crypted or filtered file; callers
+// should treat any status other than VerifyOK as a failure. Attributes are
+// always read from the index, since git before 2.40 cannot read them from
+// a commit.
+func (g *GitCrypt) Verify(repoPath string, keys []Key, source string) ([]VerifyResult, error) {
+	var files []gitFile
+	var err error
+	switch source {
+	case "":
+		files, err = gitListWorkTree(repoPath)
+	case "index":
+		files, err = gitListFiles(repoPath, "")
+	default:
+		files, err = gitListFiles(repoPath, source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	attrs, err := gitFilterAttributes(repoPath, nil, paths, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs *gitBlobReader
+	if source != "" {
+		blobs, err = newGitBlobReader(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		defer blobs.Close()
+	}
+
+	results := make([]VerifyResult, 0)
+	for _, f := range files {
+		var data []byte
+		if blobs != nil {
+			data, err = blobs.Read(f.Blob)
+		} else {
+			data, err = os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(f.Path)))
+		}
+		if err != nil {
+			return results, err
+		}
+
+		keyName, filtered := filterKeyName(attrs[f.Path])
+		if !bytes.HasPrefix(data, gitCryptHeader) {
+			if filtered && source != "" && len(data) > 0 {
+				results = append(results, VerifyResult{Path: f.Path, KeyName: keyName, Status: VerifyNotEncrypted})
+			}
+			continue
+		}
+		result := verifyBlob(data, keys, keyName, filtered)
+		result.Path = f.Path
+		results = append(results, result)
+		g.logger().Debug("verified file", "path", f.Path, "status", result.Status.String())
+	}
+	return results, nil
+}
+
+// VerifyFailed returns the results which are not VerifyOK.
+func VerifyFailed(results []VerifyResult) []VerifyResult {
+	failed := make([]VerifyResult, 0)
+	for _, r := range results {
+		if r.Status != VerifyOK {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// filterKeyName maps a filter attribute to the key name it selects,
+// reporting whether it is a git-crypt filter at all.
+func filterKeyName(filter string) (string, bool) {
+	if filter == "git-crypt" {
+		return "", true
+	}
+	if strings.HasPrefix(filter, "git-crypt-") {
+		return strings.TrimPrefix(filter, "git-crypt-"), true
+	}
+	return "", false
+}
+
+// verifyBlob checks a git-crypted blob against the available keys. If the
+// key name is known from the attributes, only that key is tried, so a
+// failure can be told apart from a missing key.
+func verifyBlob(data []byte, keys []Key, keyName string, known bool) VerifyResult {
+	if len(data) < gitCryptHeaderLen {
+		return VerifyResult{KeyName: keyName, Status: VerifyTruncated}
+	}
+	tried := false
+	for _, key := range keys {
+		if known && key.KeyName != keyName {
+			continue
+		}
+		for _, entry := range key.Entries {
+			tried = true
+			plain, err := decryptBlob(entry, data)
+			if err != nil {
+				continue
+			}
+			status := VerifyOK
+			if bytes.HasPrefix(plain, gitCryptHeader) {
+				status = VerifyDoubleEncrypted
+			}
+			return VerifyResult{KeyName: key.KeyName, Status: status}
+		}
+	}
+	switch {
+	case !tried:
+		return VerifyResult{KeyName: keyName, Status: VerifyNoKey}
+	case !known:
+		// Without attributes a mismatch may just mean another key
+		return VerifyResult{Status: VerifyNoKey}
+	case len(data) == gitCryptHeaderLen:
+		return VerifyResult{KeyName: keyName, Status: VerifyTruncated}
+	}
+	return VerifyResult{KeyName: keyName, Status: VerifyTampered}
+}
+
+// decryptBlob decrypts a whole git-crypted blob with a key entry, checking
+// its HMAC.
+func decryptBlob(entry KeyEntry, data []byte) ([]byte, error) {
+	if len(data) < gitCryptHeaderLen || !bytes.HasPrefix(data, gitCryptHeader) {
+		return nil, ErrNotEncrypted
+	}
+	nonce := data[10:gitCryptHeaderLen]
+	ciphertext := data[gitCryptHeaderLen:]
+	plain := make([]byte, len(ciphertext))
+	aes := NewAesCtrEncryptor(entry.AesKey, nonce)
+	defer aes.Destroy()
+	err := aes.process(ciphertext, plain, uint32(len(ciphertext)))
+	if err != nil {
+		return nil, err
+	}
+	h := NewHMac(entry.HmacKey)
+	h.Write(plain)
+	if !hmac.Equal(h.Result()[:aesEncryptorNonceLen], nonce) {
+		return nil, ErrTampered
+	}
+	return plain, nil
+}
+
+// encryptBlob encrypts a whole file with a key entry, producing the same
+// deterministic output as git-crypt's clean filter.
+func encryptBlob(entry KeyEntry, plain []byte) ([]byte, error) {
+	h := NewHMac(entry.HmacKey)
+	h.Write(plain)
+	nonce := h.Result()[:aesEncryptorNonceLen]
+
+	out := make([]byte, gitCryptHeaderLen+len(plain))
+	copy(out, gitCryptHeader)
+	copy(out[10:], nonce)
+	aes := NewAesCtrEncryptor(entry.AesKey, nonce)
+	defer aes.Destroy()
+	err := aes.process(plain, out[gitCryptHeaderLen:], uint32(len(plain)))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package gitcrypt
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// testGitRepo creates a repository holding files, committed if commit is
+// set, and returns its path.
+func testGitRepo(t *testing.T, files map[string][]byte, commit bool) string {
+	repo := t.TempDir()
+	testGit(t, repo, "init", "-q")
+	testWriteFiles(t, repo, files)
+	testGit(t, repo, "add", ".")
+	if commit {
+		testGit(t, repo, "commit", "-q", "-m", "test")
+	}
+	return repo
+}
+
+func testGit(t *testing.T, repo string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s", args, out)
+	}
+	return string(out)
+}
+
+func testWriteFiles(t *testing.T, repo string, files map[string][]byte) {
+	for name, data := range files {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testEncrypt(t *testing.T, key Key, plain string) []byte {
+	enc, err := encryptBlob(key.Entries[0], []byte(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func Test_Verify(t *testing.T) {
+	key := testRepoKey(t, "")
+	infra := testRepoKey(t, "infra")
+
+	tampered := testEncrypt(t, key, "database password")
+	tampered[len(tampered)-1] ^= 1
+	repo := testGitRepo(t, map[string][]byte{
+		".gitattributes":   []byte("secret/** filter=git-crypt diff=git-crypt\ninfra/** filter=git-crypt-infra\n"),
+		"README":           []byte("hello"),
+		"secret/ok":        testEncrypt(t, key, "api token"),
+		"secret/empty":     testEncrypt(t, key, ""),
+		"secret/tampered":  tampered,
+		"secret/truncated": testEncrypt(t, key, "ssh key")[:gitCryptHeaderLen],
+		"secret/double":    testEncrypt(t, key, string(testEncrypt(t, key, "twice"))),
+		"secret/plain":     []byte("oops, committed in the clear"),
+		"infra/ok":         testEncrypt(t, infra, "terraform state"),
+	}, true)
+
+	expected := map[string]VerifyStatus{
+		"secret/ok":        VerifyOK,
+		"secret/empty":     VerifyOK,
+		"secret/tampered":  VerifyTampered,
+		"secret/truncated": VerifyTruncated,
+		"secret/double":    VerifyDoubleEncrypted,
+		"secret/plain":     VerifyNotEncrypted,
+		"infra/ok":         VerifyNoKey,
+	}
+
+	g := GitCrypt{}
+	for _, source := range []string{"HEAD", "index"} {
+		results, err := g.Verify(repo, []Key{key}, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(expected) {
+			t.Errorf("%s: expected %d results, got %d: %v", source, len(expected), len(results), results)
+		}
+		for _, r := range results {
+			if r.Status != expected[r.Path] {
+				t.Errorf("%s: %s: expected %s, got %s", source, r.Path, expected[r.Path], r.Status)
+			}
+		}
+	}
+
+	// With the infra key too, only the broken files fail
+	results, err := g.Verify(repo, []Key{key, infra}, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := make([]string, 0)
+	for _, r := range VerifyFailed(results) {
+		failed = append(failed, r.Path)
+	}
+	sort.Strings(failed)
+	if len(failed) != 4 || failed[0] != "secret/double" || failed[3] != "secret/truncated" {
+		t.Errorf("unexpected failures %v", failed)
+	}
+
+	// Plain text in the working tree is just a decrypted file
+	results, err = g.Verify(repo, []Key{key, infra}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(expected)-1 {
+		t.Errorf("working tree: expected %d results, got %v", len(expected)-1, results)
+	}
+}
@@ -0,0 +1,174 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyWrapper wraps and unwraps serialized repository keys for one kind of
+// recipient. Wrapped keys are stored as
+// $REPOPATH/.git-crypt/keys/<name>/<version>/<id><extension>.
+type KeyWrapper interface {
+	// Extension returns the file extension, including the leading dot,
+	// of the key files handled by this wrapper.
+	Extension() string
+	// Recipients returns the identifiers of the wrapped key files this
+	// wrapper is able to unwrap.
+	Recipients() []string
+	// Wrap encrypts a serialized Key for recipient, returning the
+	// identifier the wrapped key file should be stored under.
+	Wrap(plain []byte, recipient string) (string, []byte, error)
+	// Unwrap decrypts a serialized Key from a wrapped key file stored under
+	// the identifier id.
+	Unwrap(wrapped []byte, id string) ([]byte, error)
+}
+
+// keyDirectory returns the directory holding the wrapped key files for a
+// key name and version.
+func keyDirectory(keysPath string, keyName string, keyVersion uint32) string {
+	path := keysPath + string(os.PathSeparator)
+	if keyName == "" {
+		path += "default"
+	} else {
+		path += keyName
+	}
+	return path + string(os.PathSeparator) + fmt.Sprintf("%d", keyVersion)
+}
+
+// UnwrapRepoKey unwraps a repository key, given:
+//   - w: The KeyWrapper used to unwrap the key files.
+//   - keyName: Name of the key set being used. Empty defaults to "default".
+//   - keyVersion: Version of the git-crypt keys.
+//   - recipients: Identifiers of the key files to attempt to unwrap. If nil,
+//     the recipients reported by w are used.
+//   - keysPath: Root path to the repository key directory (should be $REPOPATH/.git-crypt/keys)
+func (g *GitCrypt) UnwrapRepoKey(w KeyWrapper, keyName string, keyVersion uint32, recipients []string, keysPath string) (Key, error) {
+	keyFile := Key{}
+
+	if recipients == nil {
+		recipients = w.Recipients()
+	}
+
+	failures := make([]error, 0)
+	for _, recipient := range recipients {
+		path := keyDirectory(keysPath, keyName, keyVersion) + string(os.PathSeparator) + recipient + w.Extension()
+		if !g.fileExists(path) {
+			continue
+		}
+		g.logger().Debug("unwrapping key file", "file", path)
+
+		wrapped, err := g.readFile(path)
+		if err != nil {
+			g.logger().Debug("unable to read key file", "file", path, "err", err)
+			failures = append(failures, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		decryptedContents, err := w.Unwrap(wrapped, recipient)
+		if err != nil {
+			g.logger().Debug("unable to unwrap key file", "file", path, "err", err)
+			failures = append(failures, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		if len(decryptedContents) == 0 {
+			failures = append(failures, fmt.Errorf("%s: empty key", path))
+			continue
+		}
+
+		var thisVersionKeyFile Key
+		err = thisVersionKeyFile.Load(bytes.NewBuffer(decryptedContents))
+		wipe(decryptedContents)
+		if err != nil {
+			return keyFile, fmt.Errorf("unable to load version key file: %w", err)
+		}
+		thisVersionEntry, err := thisVersionKeyFile.Get(keyVersion)
+		if err != nil {
+			return keyFile, fmt.Errorf("wrapped keyfile is malformed because it does not contain expected key version: %w", err)
+		}
+		if strings.Compare(keyName, thisVersionKeyFile.KeyName) != 0 {
+			return keyFile, fmt.Errorf("wrapped keyfile is malformed: %w", ErrKeyNameMismatch)
+		}
+		keyFile.KeyName = keyName
+		keyFile.Entries = append(keyFile.Entries, thisVersionEntry)
+		return keyFile, nil
+	}
+
+	// Say why each candidate failed
+	if len(failures) == 0 {
+		failures = append(failures, fmt.Errorf("%s: no wrapped key for %s", keyDirectory(keysPath, keyName, keyVersion), strings.Join(recipients, ", ")))
+	}
+	return keyFile, errors.Join(append([]error{fmt.Errorf("no secret keys: %w", ErrNoMatchingKey)}, failures...)...)
+}
+
+// UnwrapRepoKeys unwraps every repository key set which w is able to
+// unwrap.
+func (g *GitCrypt) UnwrapRepoKeys(w KeyWrapper, keyVersion uint32, recipients []string, keysPath string) ([]Key, error) {
+	successful := false
+	keyFiles := make([]Key, 0)
+	failures := make([]error, 0)
+
+	dirents := make([]string, 0)
+	if g.fileExists(keysPath) {
+		var err error
+		dirents, err = g.readDirNames(keysPath)
+		if err != nil {
+			return keyFiles, err
+		}
+	}
+
+	for _, dirent := range dirents {
+		g.logger().Debug("unwrapping repository keys", "dir", dirent)
+		keyName := ""
+		if strings.Compare(dirent, "default") != 0 {
+			if err := validateKeyName(dirent); err != nil {
+				continue
+			}
+			keyName = dirent
+		}
+
+		keyFile, err := g.UnwrapRepoKey(w, keyName, keyVersion, recipients, keysPath)
+		if err == nil {
+			keyFiles = append(keyFiles, keyFile)
+			successful = true
+		} else {
+			failures = append(failures, fmt.Errorf("key %s: %w", dirent, err))
+		}
+	}
+	if !successful {
+		return keyFiles, errors.Join(append([]error{fmt.Errorf("unsuccessful: %w", ErrNoMatchingKey)}, failures...)...)
+	}
+	return keyFiles, nil
+}
+
+// WrapRepoKey wraps the latest entry of a repository key for recipient and
+// writes it into the repository key directory, returning the path of the
+// wrapped key file.
+func (g *GitCrypt) WrapRepoKey(w KeyWrapper, key Key, recipient string, keysPath string) (string, error) {
+	entry, err := key.Latest()
+	if err != nil {
+		return "", err
+	}
+
+	var plain bytes.Buffer
+	defer func() { wipe(plain.Bytes()) }()
+	err = Key{KeyName: key.KeyName, Entries: []KeyEntry{entry}}.Store(&plain)
+	if err != nil {
+		return "", err
+	}
+
+	id, wrapped, err := w.Wrap(plain.Bytes(), recipient)
+	if err != nil {
+		return "", err
+	}
+
+	dir := keyDirectory(keysPath, key.KeyName, entry.Version)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	path := dir + string(os.PathSeparator) + id + w.Extension()
+	g.logger().Debug("writing wrapped key", "file", path)
+	return path, os.WriteFile(path, wrapped, 0600)
+}
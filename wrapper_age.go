@@ -0,0 +1,121 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// AgeWrapper is a KeyWrapper which wraps repository keys for age X25519
+// recipients, so that access can be granted with a one line public key.
+// Wrapped key files are named after a hash of the recipient string.
+type AgeWrapper struct {
+	// Identities are used for unwrapping. They are not needed for wrapping.
+	Identities []*age.X25519Identity
+}
+
+// NewAgeWrapper creates an AgeWrapper from a set of identities.
+func NewAgeWrapper(identities ...*age.X25519Identity) *AgeWrapper {
+	return &AgeWrapper{Identities: identities}
+}
+
+// NewAgeWrapperFromFile creates an AgeWrapper from an age identity file,
+// as produced by age-keygen.
+func NewAgeWrapperFromFile(path string) (*AgeWrapper, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	parsed, err := age.ParseIdentities(fp)
+	if err != nil {
+		return nil, err
+	}
+	w := &AgeWrapper{}
+	for _, i := range parsed {
+		if x, ok := i.(*age.X25519Identity); ok {
+			w.Identities = append(w.Identities, x)
+		}
+	}
+	if len(w.Identities) == 0 {
+		return nil, errors.New("no X25519 identities in " + path)
+	}
+	return w, nil
+}
+
+// AgeRecipientID returns the identifier under which a key wrapped for an
+// age recipient is stored.
+func AgeRecipientID(recipient string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(recipient)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Extension implements KeyWrapper
+func (a *AgeWrapper) Extension() string {
+	return ".age"
+}
+
+// Recipients implements KeyWrapper
+func (a *AgeWrapper) Recipients() []string {
+	recipients := make([]string, 0)
+	for _, i := range a.Identities {
+		recipients = append(recipients, AgeRecipientID(i.Recipient().String()))
+	}
+	return recipients
+}
+
+// Wrap implements KeyWrapper. recipient is an age public key ("age1...").
+func (a *AgeWrapper) Wrap(plain []byte, recipient string) (string, []byte, error) {
+	r, err := age.ParseX25519Recipient(strings.TrimSpace(recipient))
+	if err != nil {
+		return "", []byte{}, err
+	}
+	wrapped, err := ageEncrypt(plain, r)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	return AgeRecipientID(recipient), wrapped, nil
+}
+
+// Unwrap implements KeyWrapper
+func (a *AgeWrapper) Unwrap(wrapped []byte, id string) ([]byte, error) {
+	identities := make([]age.Identity, 0)
+	for _, i := range a.Identities {
+		identities = append(identities, i)
+	}
+	return ageDecrypt(wrapped, identities...)
+}
+
+func ageEncrypt(plain []byte, recipients ...age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return []byte{}, err
+	}
+	_, err = w.Write(plain)
+	if err != nil {
+		return []byte{}, err
+	}
+	err = w.Close()
+	if err != nil {
+		return []byte{}, err
+	}
+	return buf.Bytes(), nil
+}
+
+func ageDecrypt(wrapped []byte, identities ...age.Identity) ([]byte, error) {
+	if len(identities) == 0 {
+		return []byte{}, errors.New("no identities")
+	}
+	r, err := age.Decrypt(bytes.NewReader(wrapped), identities...)
+	if err != nil {
+		return []byte{}, err
+	}
+	return io.ReadAll(r)
+}
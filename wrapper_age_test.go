@@ -0,0 +1,61 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func Test_AgeWrapper(t *testing.T) {
+	g := GitCrypt{}
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, ".git-crypt", "keys")
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(dir, "key.txt")
+	err = os.WriteFile(identityFile, []byte("# test identity\n"+identity.String()+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := testRepoKey(t, "")
+	// Wrapping only needs the public key
+	path, err := g.WrapRepoKey(NewAgeWrapper(), key, identity.Recipient().String(), keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != AgeRecipientID(identity.Recipient().String())+".age" {
+		t.Errorf("unexpected wrapped key path %s", path)
+	}
+
+	// Recipients wrapped for GPG alongside are unaffected
+	_, err = g.WrapRepoKey(NewOpenPGPWrapper(openpgp.EntityList{testEntity(t, "alice")}), key, "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewAgeWrapperFromFile(identityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := g.UnwrapRepoKey(w, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped.Entries[0].AesKey, key.Entries[0].AesKey) {
+		t.Error("unwrapped key does not match original")
+	}
+
+	other, _ := age.GenerateX25519Identity()
+	_, err = g.UnwrapRepoKey(NewAgeWrapper(other), "", 0, nil, keysPath)
+	if err == nil {
+		t.Error("expected unwrap with a different identity to fail")
+	}
+}
@@ -0,0 +1,115 @@
+package gitcrypt
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+// OpenPGPWrapper is the default KeyWrapper, which wraps repository keys
+// for OpenPGP recipients in the same format as git-crypt itself. Wrapped
+// key files are named after the recipient's fingerprint.
+type OpenPGPWrapper struct {
+	// Keyring holds the keys used for wrapping and unwrapping. Secret keys
+	// are only required for unwrapping, and not even then if Agent is set.
+	Keyring openpgp.EntityList
+	// Prompt is an optional callback used to unlock locked secret keys.
+	Prompt gpg.PromptFunc
+	// Agent is an optional gpg-agent connection used for unwrapping.
+	Agent *gpg.Agent
+	// Signer is an optional secret key which signs wrapped key files, so
+	// that recipients can tell who added them.
+	Signer *openpgp.Entity
+	// Trusted enables strict verification when it is not empty: wrapped
+	// key files must then be signed by one of these keys, or unwrapping
+	// fails.
+	Trusted openpgp.EntityList
+	// Logger optionally receives diagnostics. If it is nil, nothing is
+	// logged.
+	Logger *slog.Logger
+}
+
+// NewOpenPGPWrapper creates an OpenPGPWrapper for a keyring.
+func NewOpenPGPWrapper(keyring openpgp.EntityList) *OpenPGPWrapper {
+	return &OpenPGPWrapper{Keyring: keyring}
+}
+
+// openPGPWrapper creates the OpenPGPWrapper used by the GPG specific
+// methods of GitCrypt, inheriting its prompt and agent.
+func (g *GitCrypt) openPGPWrapper(keyring openpgp.EntityList) *OpenPGPWrapper {
+	return &OpenPGPWrapper{Keyring: keyring, Prompt: g.Prompt, Agent: g.Agent, Trusted: g.Trusted, Logger: g.logger()}
+}
+
+// Extension implements KeyWrapper
+func (o *OpenPGPWrapper) Extension() string {
+	return ".gpg"
+}
+
+// Recipients implements KeyWrapper, returning the fingerprints of all of
+// the keys in the keyring.
+func (o *OpenPGPWrapper) Recipients() []string {
+	recipients := make([]string, 0)
+	for _, e := range o.Keyring {
+		recipients = append(recipients, gpg.Fingerprint(e))
+	}
+	return recipients
+}
+
+// Wrap implements KeyWrapper. recipient may be a short key ID or a
+// fingerprint; if it is empty, the first key in the keyring is used.
+func (o *OpenPGPWrapper) Wrap(plain []byte, recipient string) (string, []byte, error) {
+	e := o.entity(recipient)
+	if e == nil {
+		return "", []byte{}, errors.New("OpenPGPWrapper.Wrap(): unable to locate key " + recipient)
+	}
+	var wrapped []byte
+	var err error
+	if o.Signer != nil {
+		wrapped, err = gpg.EncryptSigned(plain, openpgp.EntityList{e}, o.Signer, o.Prompt)
+	} else {
+		wrapped, err = gpg.Encrypt(plain, openpgp.EntityList{e}, gpg.EntityID(e), "")
+	}
+	if err != nil {
+		return "", []byte{}, err
+	}
+	return gpg.Fingerprint(e), wrapped, nil
+}
+
+// Unwrap implements KeyWrapper
+func (o *OpenPGPWrapper) Unwrap(wrapped []byte, id string) ([]byte, error) {
+	if len(o.Trusted) > 0 {
+		var plain []byte
+		var signer *openpgp.Entity
+		var err error
+		if o.Agent != nil {
+			plain, signer, err = o.Agent.DecryptVerified(wrapped, o.Keyring, o.Trusted)
+		} else {
+			plain, signer, err = gpg.DecryptVerified(wrapped, o.Keyring, o.Trusted, o.Prompt)
+		}
+		if err != nil {
+			return []byte{}, fmt.Errorf("wrapped key for %s failed verification: %w", id, err)
+		}
+		o.logger().Debug("wrapped key verified", "recipient", id, "signer", gpg.Fingerprint(signer))
+		return plain, nil
+	}
+	if o.Agent != nil {
+		return o.Agent.Decrypt(wrapped, o.Keyring)
+	}
+	return gpg.DecryptWithPrompt(wrapped, o.Keyring, o.Prompt)
+}
+
+func (o *OpenPGPWrapper) entity(recipient string) *openpgp.Entity {
+	if recipient == "" && len(o.Keyring) > 0 {
+		return o.Keyring[0]
+	}
+	for _, e := range o.Keyring {
+		if gpg.EntityID(e) == recipient || strings.EqualFold(gpg.Fingerprint(e), recipient) {
+			return e
+		}
+	}
+	return nil
+}
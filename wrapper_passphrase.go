@@ -0,0 +1,174 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// passphraseMagic prefixes key files wrapped by PassphraseWrapper.
+	passphraseMagic   = "\x00GITCRYPTPASS"
+	passphraseSaltLen = 16
+
+	// Upper bounds of the Argon2id parameters read from wrapped key files,
+	// so that a planted file cannot make unlocking hang or exhaust memory
+	passphraseMaxTime    = 10
+	passphraseMaxMemory  = 1024 * 1024 // KiB
+	passphraseMaxThreads = 16
+)
+
+// PassphraseWrapper is a KeyWrapper which wraps repository keys with a key
+// derived from a passphrase using Argon2id, for collaborators without GPG,
+// age or SSH keys. The Argon2id parameters and salt are stored in the
+// wrapped key file. OpenPGP symmetrically encrypted key files, such as
+// those produced by "gpg -c", can also be unwrapped.
+type PassphraseWrapper struct {
+	// Label names the wrapped key file, allowing several passphrases
+	// for one repository. Defaults to "passphrase".
+	Label string
+	// Prompt is called to obtain the passphrase
+	Prompt gpg.PromptFunc
+	// Time, Memory (in KiB) and Threads are the Argon2id parameters used
+	// when wrapping. Zero values select the RFC 9106 recommended
+	// parameters for memory constrained environments. They may be at most
+	// 10, 1 GiB and 16.
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// NewPassphraseWrapper creates a PassphraseWrapper for the given label,
+// with the default Argon2id parameters.
+func NewPassphraseWrapper(label string, prompt gpg.PromptFunc) *PassphraseWrapper {
+	return &PassphraseWrapper{Label: label, Prompt: prompt}
+}
+
+// Extension implements KeyWrapper
+func (p *PassphraseWrapper) Extension() string {
+	return ".pass"
+}
+
+// Recipients implements KeyWrapper
+func (p *PassphraseWrapper) Recipients() []string {
+	return []string{p.label()}
+}
+
+// Wrap implements KeyWrapper. recipient is the label of the wrapped key
+// file; if it is empty, Label is used.
+func (p *PassphraseWrapper) Wrap(plain []byte, recipient string) (string, []byte, error) {
+	if recipient == "" {
+		recipient = p.label()
+	}
+	if err := validateKeyName(recipient); err != nil {
+		return "", []byte{}, fmt.Errorf("invalid passphrase label: %s", err.Error())
+	}
+	passphrase, err := p.passphrase(recipient, 1)
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	time, memory, threads := p.Time, p.Memory, p.Threads
+	if time == 0 {
+		time = 3
+	}
+	if memory == 0 {
+		memory = 64 * 1024
+	}
+	if threads == 0 {
+		threads = 4
+	}
+	if !validArgon2Params(time, memory, threads) {
+		return "", []byte{}, errors.New("PassphraseWrapper: Argon2id parameters out of range")
+	}
+
+	var header bytes.Buffer
+	header.WriteString(passphraseMagic)
+	writeBigEndianUint32(&header, time)
+	writeBigEndianUint32(&header, memory)
+	header.WriteByte(threads)
+	salt := make([]byte, passphraseSaltLen)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	header.Write(salt)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	header.Write(nonce)
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, salt, time, memory, threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return "", []byte{}, err
+	}
+	return recipient, append(header.Bytes(), aead.Seal(nil, nonce, plain, header.Bytes())...), nil
+}
+
+// Unwrap implements KeyWrapper, asking for the passphrase again, up to
+// gpg.MaxPromptAttempts times, if it is rejected.
+func (p *PassphraseWrapper) Unwrap(wrapped []byte, id string) ([]byte, error) {
+	if !bytes.HasPrefix(wrapped, []byte(passphraseMagic)) {
+		// Not ours; try it as an OpenPGP symmetrically encrypted message
+		return gpg.DecryptWithPrompt(wrapped, nil, p.Prompt)
+	}
+
+	headerLen := len(passphraseMagic) + 4 + 4 + 1 + passphraseSaltLen + chacha20poly1305.NonceSizeX
+	if len(wrapped) < headerLen {
+		return []byte{}, errors.New("PassphraseWrapper: malformed wrapped key")
+	}
+	r := bytes.NewReader(wrapped[len(passphraseMagic):headerLen])
+	time, _ := readBigEndianUint32(r)
+	memory, _ := readBigEndianUint32(r)
+	threads, _ := r.ReadByte()
+	rest := wrapped[headerLen-passphraseSaltLen-chacha20poly1305.NonceSizeX : headerLen]
+	salt, nonce := rest[:passphraseSaltLen], rest[passphraseSaltLen:]
+	if !validArgon2Params(time, memory, threads) {
+		return []byte{}, errors.New("PassphraseWrapper: malformed wrapped key")
+	}
+
+	for attempt := 1; attempt <= gpg.MaxPromptAttempts; attempt++ {
+		passphrase, err := p.passphrase(id, attempt)
+		if err != nil {
+			return []byte{}, err
+		}
+		aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, salt, time, memory, threads, chacha20poly1305.KeySize))
+		if err != nil {
+			return []byte{}, err
+		}
+		plain, err := aead.Open(nil, nonce, wrapped[headerLen:], wrapped[:headerLen])
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return []byte{}, fmt.Errorf("%w (giving up after %d attempts)", gpg.ErrBadPassphrase, gpg.MaxPromptAttempts)
+}
+
+// validArgon2Params reports whether Argon2id parameters are within the
+// bounds PassphraseWrapper accepts.
+func validArgon2Params(time uint32, memory uint32, threads uint8) bool {
+	return time >= 1 && time <= passphraseMaxTime &&
+		memory >= 8*uint32(threads) && memory <= passphraseMaxMemory &&
+		threads >= 1 && threads <= passphraseMaxThreads
+}
+
+func (p *PassphraseWrapper) label() string {
+	if p.Label == "" {
+		return "passphrase"
+	}
+	return p.Label
+}
+
+func (p *PassphraseWrapper) passphrase(id string, attempt int) ([]byte, error) {
+	if p.Prompt == nil {
+		return nil, gpg.ErrNoPassphrase
+	}
+	return p.Prompt("repository key passphrase "+id, attempt)
+}
@@ -0,0 +1,127 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+)
+
+func Test_PassphraseWrapper(t *testing.T) {
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+
+	// Keep the test fast; the parameters are read back from the file
+	w := &PassphraseWrapper{Label: "ops", Time: 1, Memory: 1024, Threads: 1,
+		Prompt: func(desc string, attempt int) ([]byte, error) {
+			return []byte("correct horse"), nil
+		}}
+	key := testRepoKey(t, "")
+	path, err := g.WrapRepoKey(w, key, "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "ops.pass" {
+		t.Errorf("unexpected wrapped key path %s", path)
+	}
+
+	calls := 0
+	unlock := NewPassphraseWrapper("ops", func(desc string, attempt int) ([]byte, error) {
+		calls++
+		if attempt == 1 {
+			return []byte("wrong"), nil
+		}
+		return []byte("correct horse"), nil
+	})
+	unwrapped, err := g.UnwrapRepoKey(unlock, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 prompts, got %d", calls)
+	}
+	if !bytes.Equal(unwrapped.Entries[0].AesKey, key.Entries[0].AesKey) {
+		t.Error("unwrapped key does not match original")
+	}
+
+	_, err = g.UnwrapRepoKey(NewPassphraseWrapper("ops", gpg.EnvPrompt("GITCRYPT_TEST_UNSET")), "", 0, nil, keysPath)
+	if err == nil {
+		t.Error("expected unlock without a passphrase to fail")
+	}
+}
+
+func Test_PassphraseWrapperSymmetric(t *testing.T) {
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+
+	// A key file wrapped with "gpg -c" and saved as <label>.pass
+	var plain bytes.Buffer
+	key := testRepoKey(t, "")
+	err := key.Store(&plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := gpg.EncryptSymmetric(plain.Bytes(), []byte("sekrit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := keyDirectory(keysPath, "", 0)
+	os.MkdirAll(dir, 0755)
+	err = os.WriteFile(filepath.Join(dir, "passphrase.pass"), wrapped, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := g.UnwrapRepoKey(NewPassphraseWrapper("", func(desc string, attempt int) ([]byte, error) {
+		return []byte("sekrit"), nil
+	}), "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped.Entries[0].HmacKey, key.Entries[0].HmacKey) {
+		t.Error("unwrapped key does not match original")
+	}
+
+	_, err = NewPassphraseWrapper("", gpg.EnvPrompt("GITCRYPT_TEST_UNSET")).Unwrap(wrapped, "passphrase")
+	if !errors.Is(err, gpg.ErrNoPassphrase) {
+		t.Errorf("expected ErrNoPassphrase, got %v", err)
+	}
+}
+
+func Test_PassphraseWrapperLimits(t *testing.T) {
+	prompted := false
+	w := &PassphraseWrapper{Time: 1, Memory: 1024, Threads: 1,
+		Prompt: func(desc string, attempt int) ([]byte, error) {
+			prompted = true
+			return []byte("correct horse"), nil
+		}}
+	_, wrapped, err := w.Wrap([]byte("key"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parameters planted in a key file are bounded before any work is done
+	offset := len(passphraseMagic)
+	for name, patch := range map[string]func([]byte){
+		"time":    func(b []byte) { binary.BigEndian.PutUint32(b[offset:], passphraseMaxTime+1) },
+		"memory":  func(b []byte) { binary.BigEndian.PutUint32(b[offset+4:], passphraseMaxMemory+1) },
+		"threads": func(b []byte) { b[offset+8] = passphraseMaxThreads + 1 },
+	} {
+		planted := append([]byte{}, wrapped...)
+		patch(planted)
+		prompted = false
+		_, err = w.Unwrap(planted, "passphrase")
+		if err == nil || prompted {
+			t.Errorf("%s: expected out of range parameters to be rejected, got %v", name, err)
+		}
+	}
+
+	_, _, err = (&PassphraseWrapper{Time: passphraseMaxTime + 1, Prompt: w.Prompt}).Wrap([]byte("key"), "")
+	if err == nil {
+		t.Error("expected wrapping with out of range parameters to fail")
+	}
+}
@@ -0,0 +1,308 @@
+package gitcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/jbuchbinder/go-git-crypt/gpg"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// sshAgentMagic prefixes key files wrapped by SSHAgentWrapper.
+	sshAgentMagic = "GITCRYPTSSHAGENT"
+	// sshAgentChallenge is signed, together with a per-file salt, to derive
+	// the wrapping key for SSHAgentWrapper.
+	sshAgentChallenge = "go-git-crypt ssh-agent key wrapping v1"
+	sshAgentSaltLen   = 32
+)
+
+// SSHRecipientID returns the identifier under which a key wrapped for an
+// SSH public key is stored.
+func SSHRecipientID(pub ssh.PublicKey) string {
+	sum := sha256.Sum256(pub.Marshal())
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseAuthorizedKeys reads an authorized_keys formatted list, returning
+// the ssh-ed25519 and ssh-rsa keys in it as recipients for SSHWrapper.
+// Other key types, options and comments are skipped.
+func ParseAuthorizedKeys(in io.Reader) ([]string, error) {
+	recipients := make([]string, 0)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return recipients, err
+		}
+		switch pub.Type() {
+		case ssh.KeyAlgoED25519, ssh.KeyAlgoRSA:
+			recipients = append(recipients, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))))
+		}
+	}
+	return recipients, scanner.Err()
+}
+
+type sshIdentity struct {
+	pub      ssh.PublicKey
+	identity age.Identity
+}
+
+// SSHWrapper is a KeyWrapper which wraps repository keys for ssh-ed25519
+// and ssh-rsa public keys, using the age SSH recipient format, and unwraps
+// them with the matching private keys. Wrapped key files are named after
+// a hash of the public key.
+type SSHWrapper struct {
+	identities []sshIdentity
+}
+
+// NewSSHWrapper creates an SSHWrapper without any private keys, which can
+// only be used for wrapping.
+func NewSSHWrapper() *SSHWrapper {
+	return &SSHWrapper{}
+}
+
+// NewSSHWrapperFromFile creates an SSHWrapper from an OpenSSH or PEM
+// private key file. prompt is used to ask for the passphrase of encrypted
+// keys, and may be nil if the key is not encrypted.
+func NewSSHWrapperFromFile(path string, prompt gpg.PromptFunc) (*SSHWrapper, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &SSHWrapper{}
+	return w, w.AddPrivateKey(pemBytes, path, prompt)
+}
+
+// AddPrivateKey adds a PEM encoded private key to the wrapper. desc is
+// shown when prompting for a passphrase.
+func (s *SSHWrapper) AddPrivateKey(pemBytes []byte, desc string, prompt gpg.PromptFunc) error {
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err == nil {
+		identity, err := agessh.ParseIdentity(pemBytes)
+		if err != nil {
+			return err
+		}
+		s.identities = append(s.identities, sshIdentity{pub: signer.PublicKey(), identity: identity})
+		return nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return err
+	}
+	if missing.PublicKey == nil {
+		return errors.New("SSHWrapper: encrypted private key has no embedded public key")
+	}
+	if prompt == nil {
+		return fmt.Errorf("%w: %s is encrypted", gpg.ErrNoPassphrase, desc)
+	}
+	identity, err := agessh.NewEncryptedSSHIdentity(missing.PublicKey, pemBytes, func() ([]byte, error) {
+		return prompt(desc, 1)
+	})
+	if err != nil {
+		return err
+	}
+	s.identities = append(s.identities, sshIdentity{pub: missing.PublicKey, identity: identity})
+	return nil
+}
+
+// Extension implements KeyWrapper
+func (s *SSHWrapper) Extension() string {
+	return ".ssh"
+}
+
+// Recipients implements KeyWrapper
+func (s *SSHWrapper) Recipients() []string {
+	recipients := make([]string, 0)
+	for _, i := range s.identities {
+		recipients = append(recipients, SSHRecipientID(i.pub))
+	}
+	return recipients
+}
+
+// Wrap implements KeyWrapper. recipient is a public key in authorized_keys
+// format, such as "ssh-ed25519 AAAA... user@host".
+func (s *SSHWrapper) Wrap(plain []byte, recipient string) (string, []byte, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(recipient))
+	if err != nil {
+		return "", []byte{}, err
+	}
+	r, err := agessh.ParseRecipient(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))))
+	if err != nil {
+		return "", []byte{}, err
+	}
+	wrapped, err := ageEncrypt(plain, r)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	return SSHRecipientID(pub), wrapped, nil
+}
+
+// Unwrap implements KeyWrapper
+func (s *SSHWrapper) Unwrap(wrapped []byte, id string) ([]byte, error) {
+	identities := make([]age.Identity, 0)
+	for _, i := range s.identities {
+		if id == "" || SSHRecipientID(i.pub) == id {
+			identities = append(identities, i.identity)
+		}
+	}
+	return ageDecrypt(wrapped, identities...)
+}
+
+// SSHAgentWrapper is a KeyWrapper which wraps repository keys using
+// ssh-ed25519 keys held by an ssh-agent. Since ssh-agent cannot decrypt,
+// the wrapping key is derived from the agent's (deterministic) Ed25519
+// signature over a per-file salt. This means keys can only be wrapped by
+// the holder of the private key, so it is intended for self-enrollment
+// alongside SSHWrapper.
+type SSHAgentWrapper struct {
+	Agent agent.Agent
+}
+
+// NewSSHAgentWrapper creates an SSHAgentWrapper using an ssh-agent, such as
+// an in-process agent.NewKeyring().
+func NewSSHAgentWrapper(a agent.Agent) *SSHAgentWrapper {
+	return &SSHAgentWrapper{Agent: a}
+}
+
+// NewSSHAgentWrapperFromSocket creates an SSHAgentWrapper connected to the
+// ssh-agent listening on socketPath, defaulting to $SSH_AUTH_SOCK. The
+// connection is closed when the returned close function is called.
+func NewSSHAgentWrapperFromSocket(socketPath string) (*SSHAgentWrapper, func() error, error) {
+	if socketPath == "" {
+		socketPath = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socketPath == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	c, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &SSHAgentWrapper{Agent: agent.NewClient(c)}, c.Close, nil
+}
+
+// Extension implements KeyWrapper
+func (s *SSHAgentWrapper) Extension() string {
+	return ".ssh-agent"
+}
+
+// Recipients implements KeyWrapper, returning the ssh-ed25519 keys held by
+// the agent.
+func (s *SSHAgentWrapper) Recipients() []string {
+	recipients := make([]string, 0)
+	keys, err := s.Agent.List()
+	if err != nil {
+		return recipients
+	}
+	for _, k := range keys {
+		if k.Type() == ssh.KeyAlgoED25519 {
+			recipients = append(recipients, SSHRecipientID(k))
+		}
+	}
+	return recipients
+}
+
+// Wrap implements KeyWrapper. recipient is an ssh-ed25519 public key in
+// authorized_keys format, or its SSHRecipientID, whose private key must be
+// held by the agent.
+func (s *SSHAgentWrapper) Wrap(plain []byte, recipient string) (string, []byte, error) {
+	id := recipient
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(recipient)); err == nil {
+		id = SSHRecipientID(pub)
+	}
+	pub, err := s.key(id)
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	salt := make([]byte, sshAgentSaltLen)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	aead, err := s.aead(pub, salt)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", []byte{}, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sshAgentMagic)
+	out.Write(salt)
+	out.Write(nonce)
+	out.Write(aead.Seal(nil, nonce, plain, []byte(sshAgentMagic)))
+	return id, out.Bytes(), nil
+}
+
+// Unwrap implements KeyWrapper
+func (s *SSHAgentWrapper) Unwrap(wrapped []byte, id string) ([]byte, error) {
+	pub, err := s.key(id)
+	if err != nil {
+		return []byte{}, err
+	}
+	headerLen := len(sshAgentMagic) + sshAgentSaltLen + chacha20poly1305.NonceSize
+	if len(wrapped) < headerLen || !bytes.HasPrefix(wrapped, []byte(sshAgentMagic)) {
+		return []byte{}, errors.New("SSHAgentWrapper: malformed wrapped key")
+	}
+	salt := wrapped[len(sshAgentMagic) : len(sshAgentMagic)+sshAgentSaltLen]
+	nonce := wrapped[len(sshAgentMagic)+sshAgentSaltLen : headerLen]
+	aead, err := s.aead(pub, salt)
+	if err != nil {
+		return []byte{}, err
+	}
+	return aead.Open(nil, nonce, wrapped[headerLen:], []byte(sshAgentMagic))
+}
+
+// key finds the ssh-ed25519 key in the agent with the given identifier.
+func (s *SSHAgentWrapper) key(id string) (ssh.PublicKey, error) {
+	keys, err := s.Agent.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.Type() == ssh.KeyAlgoED25519 && SSHRecipientID(k) == id {
+			return k, nil
+		}
+	}
+	return nil, errors.New("SSHAgentWrapper: ssh-ed25519 key " + id + " not available in agent")
+}
+
+// aead derives the wrapping cipher for a key and salt from the agent's
+// signature over the challenge.
+func (s *SSHAgentWrapper) aead(pub ssh.PublicKey, salt []byte) (cipher.AEAD, error) {
+	sig, err := s.Agent.Sign(pub, append([]byte(sshAgentChallenge), salt...))
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, sig.Blob, salt, []byte(sshAgentChallenge)), key)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
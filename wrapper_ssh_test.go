@@ -0,0 +1,133 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func writeSSHKey(t *testing.T, dir string, name string, priv interface{}, passphrase string) (string, string) {
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, name)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, name, []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + name
+}
+
+func Test_SSHWrapper(t *testing.T) {
+	g := GitCrypt{}
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, ".git-crypt", "keys")
+
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPath, edPub := writeSSHKey(t, dir, "id_ed25519", edPriv, "")
+	rsaPath, rsaPub := writeSSHKey(t, dir, "id_rsa", rsaPriv, "sekrit")
+
+	recipients, err := ParseAuthorizedKeys(strings.NewReader("# team\n" + edPub + "\n" + rsaPub + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 2 {
+		t.Fatalf("expected 2 recipients, got %d", len(recipients))
+	}
+
+	key := testRepoKey(t, "")
+	for _, r := range recipients {
+		_, err = g.WrapRepoKey(NewSSHWrapper(), key, r, keysPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edWrapper, err := NewSSHWrapperFromFile(edPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaWrapper, err := NewSSHWrapperFromFile(rsaPath, func(desc string, attempt int) ([]byte, error) {
+		return []byte("sekrit"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []*SSHWrapper{edWrapper, rsaWrapper} {
+		unwrapped, err := g.UnwrapRepoKey(w, "", 0, nil, keysPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped.Entries[0].HmacKey, key.Entries[0].HmacKey) {
+			t.Error("unwrapped key does not match original")
+		}
+	}
+}
+
+func Test_SSHAgentWrapper(t *testing.T) {
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	err = keyring.Add(agent.AddedKey{PrivateKey: edPriv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewSSHAgentWrapper(keyring)
+	if len(w.Recipients()) != 1 {
+		t.Fatalf("expected 1 recipient, got %d", len(w.Recipients()))
+	}
+
+	key := testRepoKey(t, "")
+	_, err = g.WrapRepoKey(w, key, w.Recipients()[0], keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := g.UnwrapRepoKey(w, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped.Entries[0].AesKey, key.Entries[0].AesKey) {
+		t.Error("unwrapped key does not match original")
+	}
+
+	// A different agent key cannot derive the wrapping key
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	other := agent.NewKeyring()
+	other.Add(agent.AddedKey{PrivateKey: otherPriv})
+	_, err = g.UnwrapRepoKey(NewSSHAgentWrapper(other), "", 0, nil, keysPath)
+	if err == nil {
+		t.Error("expected unwrap with a different agent key to fail")
+	}
+}
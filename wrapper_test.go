@@ -0,0 +1,141 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// testEntity generates a throwaway OpenPGP key for tests.
+func testEntity(t *testing.T, name string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// testRepoKey generates a fresh single-entry repository key.
+func testRepoKey(t *testing.T, keyName string) Key {
+	entry := KeyEntry{}
+	err := entry.Generate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Key{KeyName: keyName, Entries: []KeyEntry{entry}}
+}
+
+func Test_WrapRepoKeyRoundtrip(t *testing.T) {
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+	alice := testEntity(t, "alice")
+	bob := testEntity(t, "bob")
+
+	key := testRepoKey(t, "")
+	w := NewOpenPGPWrapper(openpgp.EntityList{alice})
+	path, err := g.WrapRepoKey(w, key, "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != w.Recipients()[0]+".gpg" {
+		t.Errorf("unexpected wrapped key path %s", path)
+	}
+
+	unwrapped, err := g.UnwrapRepoKey(w, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped.Entries[0].AesKey, key.Entries[0].AesKey) ||
+		!bytes.Equal(unwrapped.Entries[0].HmacKey, key.Entries[0].HmacKey) {
+		t.Error("unwrapped key does not match original")
+	}
+
+	_, err = g.UnwrapRepoKey(NewOpenPGPWrapper(openpgp.EntityList{bob}), "", 0, nil, keysPath)
+	if err == nil {
+		t.Error("expected unwrap with a different key to fail")
+	}
+}
+
+func Test_DecryptRepoKeysNamed(t *testing.T) {
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+	alice := testEntity(t, "alice")
+	w := NewOpenPGPWrapper(openpgp.EntityList{alice})
+
+	for _, name := range []string{"", "infra"} {
+		_, err := g.WrapRepoKey(w, testRepoKey(t, name), "", keysPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Invalid key names are skipped
+	err := os.MkdirAll(filepath.Join(keysPath, "not valid"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := g.DecryptRepoKeys(openpgp.EntityList{alice}, 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if keys[0].KeyName != "" || keys[1].KeyName != "infra" {
+		t.Errorf("unexpected key names %q, %q", keys[0].KeyName, keys[1].KeyName)
+	}
+}
+
+func Test_SignedWrappedKeys(t *testing.T) {
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+	alice := testEntity(t, "alice")
+	bob := testEntity(t, "bob")
+	mallory := testEntity(t, "mallory")
+
+	// alice adds bob, signing his wrapped key
+	key := testRepoKey(t, "")
+	adder := &OpenPGPWrapper{Keyring: openpgp.EntityList{bob}, Signer: alice}
+	_, err := (&GitCrypt{}).WrapRepoKey(adder, key, "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strict := GitCrypt{Trusted: openpgp.EntityList{alice}}
+	unwrapped, err := strict.DecryptRepoKey(openpgp.EntityList{bob}, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped.Entries[0].AesKey, key.Entries[0].AesKey) {
+		t.Error("unwrapped key does not match original")
+	}
+
+	// mallory replaces bob's wrapped key with an unsigned one of her own
+	_, err = (&GitCrypt{}).WrapRepoKey(NewOpenPGPWrapper(openpgp.EntityList{bob}), testRepoKey(t, ""), "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = strict.DecryptRepoKey(openpgp.EntityList{bob}, "", 0, nil, keysPath)
+	if err == nil {
+		t.Error("expected unsigned wrapped key to be rejected in strict mode")
+	}
+
+	// ... or one signed with her own key
+	_, err = (&GitCrypt{}).WrapRepoKey(&OpenPGPWrapper{Keyring: openpgp.EntityList{bob}, Signer: mallory}, testRepoKey(t, ""), "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = strict.DecryptRepoKey(openpgp.EntityList{bob}, "", 0, nil, keysPath)
+	if err == nil {
+		t.Error("expected wrapped key signed by an untrusted key to be rejected")
+	}
+
+	// Without strict mode signatures are not checked
+	_, err = (&GitCrypt{}).DecryptRepoKey(openpgp.EntityList{bob}, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,164 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VaultWrapper is a KeyWrapper which has a HashiCorp Vault transit engine
+// encrypt and decrypt repository keys, so that CI pipelines can unlock with
+// their Vault token instead of a checked-in GPG secret. Wrapped key files
+// are named after the transit key.
+type VaultWrapper struct {
+	// Address is the Vault server address, such as https://vault:8200
+	Address string
+	// Token is the Vault token used to authenticate requests
+	Token string
+	// Namespace is the optional Vault Enterprise namespace
+	Namespace string
+	// Mount is the path the transit engine is mounted at. Defaults to
+	// "transit".
+	Mount string
+	// KeyName is the name of the transit key used when no recipient is
+	// given to Wrap
+	KeyName string
+	// Client is the HTTP client used for requests. Defaults to a client
+	// with a 30 second timeout.
+	Client *http.Client
+}
+
+// NewVaultWrapperFromEnv creates a VaultWrapper for a transit key,
+// configured from the standard VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE
+// environment variables. If VAULT_TOKEN is not set, ~/.vault-token is
+// used, as with the Vault CLI.
+func NewVaultWrapperFromEnv(keyName string) (*VaultWrapper, error) {
+	v := &VaultWrapper{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		KeyName:   keyName,
+	}
+	if v.Address == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	if v.Token == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			raw, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+			if err == nil {
+				v.Token = strings.TrimSpace(string(raw))
+			}
+		}
+	}
+	if v.Token == "" {
+		return nil, errors.New("VAULT_TOKEN is not set")
+	}
+	return v, nil
+}
+
+// Extension implements KeyWrapper
+func (v *VaultWrapper) Extension() string {
+	return ".vault"
+}
+
+// Recipients implements KeyWrapper
+func (v *VaultWrapper) Recipients() []string {
+	return []string{v.KeyName}
+}
+
+// Wrap implements KeyWrapper. recipient is the name of the transit key; if
+// it is empty, KeyName is used.
+func (v *VaultWrapper) Wrap(plain []byte, recipient string) (string, []byte, error) {
+	if recipient == "" {
+		recipient = v.KeyName
+	}
+	if err := validateKeyName(recipient); err != nil {
+		return "", []byte{}, fmt.Errorf("invalid transit key name: %s", err.Error())
+	}
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := v.transit("encrypt", recipient, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plain),
+	}, &resp)
+	if err != nil {
+		return "", []byte{}, err
+	}
+	if !strings.HasPrefix(resp.Ciphertext, "vault:") {
+		return "", []byte{}, errors.New("VaultWrapper: unexpected ciphertext from transit encrypt")
+	}
+	return recipient, []byte(resp.Ciphertext + "\n"), nil
+}
+
+// Unwrap implements KeyWrapper
+func (v *VaultWrapper) Unwrap(wrapped []byte, id string) ([]byte, error) {
+	if id == "" {
+		id = v.KeyName
+	}
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := v.transit("decrypt", id, map[string]string{
+		"ciphertext": strings.TrimSpace(string(wrapped)),
+	}, &resp)
+	if err != nil {
+		return []byte{}, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+// transit calls a transit engine endpoint for a key, decoding the "data"
+// member of the response into out.
+func (v *VaultWrapper) transit(operation string, keyName string, body interface{}, out interface{}) error {
+	mount := v.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	endpoint := strings.TrimSuffix(v.Address, "/") + "/v1/" + strings.Trim(mount, "/") + "/" + operation + "/" + url.PathEscape(keyName)
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var envelope struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&envelope)
+	if err != nil {
+		return fmt.Errorf("VaultWrapper: transit %s: HTTP %d: %s", operation, res.StatusCode, err.Error())
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("VaultWrapper: transit %s: HTTP %d: %s", operation, res.StatusCode, strings.Join(envelope.Errors, "; "))
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
@@ -0,0 +1,89 @@
+package gitcrypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTransit is a stand-in for the Vault transit engine, which "encrypts"
+// by handing out opaque references to stored plaintexts.
+func fakeTransit(t *testing.T, token string) *httptest.Server {
+	var mu sync.Mutex
+	store := map[string]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/transit/encrypt/"):
+			name := strings.TrimPrefix(r.URL.Path, "/v1/transit/encrypt/")
+			ct := fmt.Sprintf("vault:v1:%s:%d", name, len(store))
+			store[ct] = body["plaintext"]
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": ct}})
+		case strings.HasPrefix(r.URL.Path, "/v1/transit/decrypt/"):
+			name := strings.TrimPrefix(r.URL.Path, "/v1/transit/decrypt/")
+			pt, ok := store[body["ciphertext"]]
+			if !ok || !strings.HasPrefix(body["ciphertext"], "vault:v1:"+name+":") {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"errors":["cipher: message authentication failed"]}`)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": pt}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[]}`)
+		}
+	}))
+}
+
+func Test_VaultWrapper(t *testing.T) {
+	srv := fakeTransit(t, "s.testtoken")
+	defer srv.Close()
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "s.testtoken")
+	w, err := NewVaultWrapperFromEnv("git-crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := GitCrypt{}
+	keysPath := filepath.Join(t.TempDir(), ".git-crypt", "keys")
+	key := testRepoKey(t, "")
+	path, err := g.WrapRepoKey(w, key, "", keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "git-crypt.vault" {
+		t.Errorf("unexpected wrapped key path %s", path)
+	}
+
+	unwrapped, err := g.UnwrapRepoKey(w, "", 0, nil, keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped.Entries[0].AesKey, key.Entries[0].AesKey) {
+		t.Error("unwrapped key does not match original")
+	}
+
+	bad := *w
+	bad.Token = "s.wrong"
+	_, err = bad.Unwrap([]byte("vault:v1:git-crypt:0"), "git-crypt")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+}